@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/aria3ppp/craft/cmd/craft/internal/craft"
+)
+
+var (
+	// leftoverDirRegexp matches the program directories older versions of
+	// craft created next to the source file
+	leftoverDirRegexp = regexp.MustCompile(`^[0-9]+_.+$`)
+	// leftoverTestRegexp matches the test files of the in-package programs
+	// older versions of craft created in the package of the source file
+	leftoverTestRegexp = regexp.MustCompile(`^craft_.+_test\.go$`)
+	// every program craft ever generated imports craft
+	leftoverMarker = []byte(`"github.com/aria3ppp/craft/`)
+)
+
+// clean removes the programs older versions of craft left in the source tree
+// rooted at the current directory when they were killed, and the cache of
+// craft if asked to.
+func clean(args []string) {
+	flags := flag.NewFlagSet("clean", flag.ExitOnError)
+	cache := flags.Bool("cache", false, "remove the cache of compiled macro programs too")
+
+	flags.Usage = func() {
+		fmt.Printf("usage: %s clean [-cache]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+
+	root, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("craft internal error: failed to get the current directory: %s\n", err)
+		os.Exit(1)
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := entry.Name()
+
+		switch {
+		case entry.IsDir() && path != root && (strings.HasPrefix(name, ".") || name == "vendor"):
+			return filepath.SkipDir
+		case entry.IsDir() && leftoverDirRegexp.MatchString(name) && isLeftover(filepath.Join(path, "program.go")):
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+
+			fmt.Printf("removed %s\n", relativeTo(root, path))
+
+			return filepath.SkipDir
+		case !entry.IsDir() && leftoverTestRegexp.MatchString(name) && isLeftover(path):
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+
+			fmt.Printf("removed %s\n", relativeTo(root, path))
+		}
+
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("error: failed to clean %s: %s\n", root, err)
+		os.Exit(1)
+	}
+
+	if !*cache {
+		return
+	}
+
+	cacheDir, err := craft.CacheDir()
+	if err != nil {
+		fmt.Printf("error: failed to find the cache: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.RemoveAll(cacheDir); err != nil {
+		fmt.Printf("error: failed to remove the cache: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("removed %s\n", cacheDir)
+}
+
+// isLeftover reports whether the file is a program generated by craft.
+func isLeftover(path string) bool {
+	content, err := os.ReadFile(path)
+
+	return err == nil && bytes.Contains(content, leftoverMarker)
+}
+
+func relativeTo(root, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return rel
+	}
+
+	return path
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/aria3ppp/craft/cmd/craft/internal/craft"
+	"github.com/samber/lo"
+)
+
+// goListPackage is the part of the output of go list -json the dependency
+// check reads.
+type goListPackage struct {
+	ImportPath string
+	Module     *struct {
+		Path    string
+		Version string
+		Main    bool
+	}
+	Error *struct {
+		Err string
+	}
+}
+
+// hostImportPath is the package every macro host imports, so the module of
+// craft must be required next to the macro modules.
+const hostImportPath = "github.com/aria3ppp/craft/host"
+
+// checkDependencies checks that every macro package is provided by a module
+// go.mod, or a module of the go.work workspace, requires, at the version it
+// is pinned to if any. It never changes go.mod or go.sum: it prints the
+// problems with the craft deps command fixing them and exits.
+func checkDependencies(ctx context.Context) {
+	// external macros are executables, not modules
+	deps := lo.Reject(lo.Values(macroPackageImports), func(dep string, _ int) bool {
+		return strings.HasPrefix(dep, craft.ExecPrefix)
+	})
+
+	slices.Sort(deps)
+
+	deps = append(deps, hostImportPath)
+
+	var goListCmdOut, goListCmdErr bytes.Buffer
+
+	args := append([]string{"list", "-e", "-json=ImportPath,Module,Error"}, deps...)
+	goListCmd := craft.Command(ctx, "go", args...)
+	goListCmd.Stdout = &goListCmdOut
+	goListCmd.Stderr = &goListCmdErr
+
+	if err := goListCmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			fmt.Printf("error: failed to list the macro packages:\n%s\n", strings.TrimSpace(goListCmdErr.String()))
+		} else {
+			fmt.Printf("[INTERNAL ERROR] [file a bug] failed to list the macro packages: %s\n", err)
+		}
+
+		os.Exit(1)
+	}
+
+	var (
+		decoder = json.NewDecoder(&goListCmdOut)
+		fixes   []string
+	)
+
+	for {
+		var pkg goListPackage
+
+		if err := decoder.Decode(&pkg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			fmt.Printf("[INTERNAL ERROR] [file a bug] failed to decode the macro packages: %s\n", err)
+			os.Exit(1)
+		}
+
+		var (
+			version = macroPackageVersions[pkg.ImportPath]
+			kind    = "macro package"
+		)
+
+		if pkg.ImportPath == hostImportPath {
+			kind = "craft package"
+		}
+
+		switch {
+		case pkg.Error != nil:
+			fmt.Printf("error: %s %q is not provided by a module in go.mod:\n\t%s\n", kind, pkg.ImportPath, strings.TrimSpace(pkg.Error.Err))
+		case isPinnable(version) && (pkg.Module == nil || pkg.Module.Main || pkg.Module.Version != version):
+			current := "the main module"
+			if pkg.Module != nil && !pkg.Module.Main {
+				current = pkg.Module.Path + "@" + pkg.Module.Version
+			}
+
+			fmt.Printf("error: %s %q is pinned to %s but go.mod provides it from %s\n", kind, pkg.ImportPath, version, current)
+		default:
+			continue
+		}
+
+		if version == "" {
+			version = "latest"
+		}
+
+		fixes = append(fixes, pkg.ImportPath+"@"+version)
+	}
+
+	if len(fixes) > 0 {
+		fmt.Printf("to add or upgrade them, run:\n\t%s deps %s\n", os.Args[0], strings.Join(fixes, " "))
+		os.Exit(1)
+	}
+}
+
+// isPinnable reports whether a macro package pinned to the version can be
+// checked against go.mod. Queries such as latest only mean something to
+// craft deps.
+func isPinnable(version string) bool {
+	return strings.HasPrefix(version, "v")
+}
+
+// deps adds or upgrades the modules of the macro packages to the versions
+// they are pinned to, or to their latest versions. It is the only command of
+// craft that changes go.mod and go.sum.
+func deps(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("deps", flag.ExitOnError)
+
+	flags.Usage = func() {
+		fmt.Printf("usage: %s deps [alias=]<import-path>[@version]...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+
+	var queries []string
+
+	for _, arg := range flags.Args() {
+		_, importURL, hasAlias := strings.Cut(arg, "=")
+		if !hasAlias {
+			importURL = arg
+		}
+
+		if strings.HasPrefix(importURL, craft.ExecPrefix) {
+			continue
+		}
+
+		importPath, version, _ := strings.Cut(importURL, "@")
+		if version == "" {
+			version = "latest"
+		}
+
+		queries = append(queries, importPath+"@"+version)
+	}
+
+	if len(queries) == 0 {
+		fmt.Printf("error: a macro import path must be provided!\n")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	goGetCmd := craft.Command(ctx, "go", append([]string{"get"}, queries...)...)
+	goGetCmd.Stdout = os.Stdout
+	goGetCmd.Stderr = os.Stdout
+
+	if err := goGetCmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			fmt.Printf("[INTERNAL ERROR] [file a bug] failed to get dependencies: %s\n", err)
+		}
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,361 @@
+package craft
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aria3ppp/craft/protocol"
+)
+
+// templateVersion is part of every cache key, bump it when the generated
+// programs change in a way their source does not tell, e.g. how they are
+// built or run.
+const templateVersion = "1"
+
+// outputsFile is the file of a cache entry recording the outputs of its
+// program for the requests with the key, by the sha256 of their content.
+const outputsFile = "outputs-%s.json"
+
+// depsFormat is the go list format printing a line for every non-standard
+// package a program depends on. Packages of modules without a version, i.e.
+// the main module and modules replaced by a directory, also list the files
+// to hash.
+const depsFormat = `{{if not .Standard}}{{.ImportPath}}` +
+	`{{with .Module}} {{.Path}}@{{.Version}}{{with .Replace}}=>{{.Path}}@{{.Version}}{{end}}{{end}}` +
+	`{{"\t"}}{{.Dir}}{{"\t"}}{{join .GoFiles " "}} {{join .CgoFiles " "}} {{join .EmbedFiles " "}}{{"\n"}}{{end}}`
+
+const (
+	// cacheMaxAge is how long an entry is kept in the cache after it was last
+	// used.
+	cacheMaxAge = 5 * 24 * time.Hour
+	// cacheTouchInterval is how often the use of an entry is recorded on its
+	// modification time, so it is not written on every run.
+	cacheTouchInterval = time.Hour
+	// cacheTrimFile is the file of the cache whose modification time records
+	// when the cache was last trimmed, and cacheTrimInterval how often it
+	// is trimmed.
+	cacheTrimFile     = "trim.txt"
+	cacheTrimInterval = 24 * time.Hour
+)
+
+// CacheStats counts the programs found in the cache.
+type CacheStats struct {
+	// UpToDate programs were not run since their outputs were up to date.
+	UpToDate atomic.Int64
+	// Hits are programs run from a binary built by a previous run.
+	Hits atomic.Int64
+	// Misses are programs built by this run.
+	Misses atomic.Int64
+	// Fresh are macros not run since their outputs were generated from the
+	// same inputs.
+	Fresh atomic.Int64
+}
+
+// CacheDir returns the directory the compiled programs are cached in.
+func CacheDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userCacheDir, "craft"), nil
+}
+
+// TrimCache removes the entries of the cache in dir unused for longer than
+// the maximum age. It does nothing if the cache was trimmed recently.
+func TrimCache(dir string) error {
+	now := time.Now()
+
+	if info, err := os.Stat(filepath.Join(dir, cacheTrimFile)); err == nil && now.Sub(info.ModTime()) < cacheTrimInterval {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < cacheMaxAge {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(filepath.Join(dir, cacheTrimFile), []byte(now.Format(time.RFC3339)+"\n"), 0o644)
+}
+
+// touchCacheEntry records the use of the cache entry, so it is not trimmed.
+func touchCacheEntry(entry string) {
+	now := time.Now()
+
+	if info, err := os.Stat(entry); err == nil && now.Sub(info.ModTime()) >= cacheTouchInterval {
+		_ = os.Chtimes(entry, now, now)
+	}
+}
+
+// cacheKey returns the key of the program in the cache. The key covers the
+// program source, the go environment, the source of the current package and
+// the versions, or the sources if they have no version, of every package the
+// program depends on. The files generated in the current package are
+// compiled into the program, so their code is part of the key too, but not
+// their headers, which change on every run. The hidden files are not
+// compiled, so they are not part of it.
+func (c *Craft) cacheKey(ctx context.Context, program []byte, inPackage bool, invocations []Invocation) (string, error) {
+	hash := sha256.New()
+
+	fmt.Fprintf(hash, "craft program %s\n", templateVersion)
+	fmt.Fprintf(hash, "protocol %d\n", protocol.Version)
+	fmt.Fprintf(hash, "in-package %t\n", inPackage)
+	hash.Write(program)
+
+	goEnv, err := c.goCommandOutput(ctx, "env", "GOVERSION", "GOOS", "GOARCH", "GOFLAGS", "GOEXPERIMENT", "CGO_ENABLED")
+	if err != nil {
+		return "", err
+	}
+
+	hash.Write(goEnv)
+
+	goFiles, err := filepath.Glob(filepath.Join(c.Context.PWD, "*.go"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, goFile := range goFiles {
+		if slices.Contains(c.hidden, goFile) {
+			continue
+		}
+
+		if _, generated := readHeader(goFile); generated {
+			err = hashCode(hash, goFile)
+		} else {
+			err = hashFile(hash, goFile)
+		}
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var macroPackages []string
+
+	for _, invocation := range invocations {
+		if invocation.Exec == "" {
+			macroPackages = append(macroPackages, invocation.Request.Package)
+		}
+	}
+
+	// the Go files of the current package are hashed above
+	err = c.hashDeps(ctx, hash, macroPackages, func(dir, file string) bool {
+		return dir == c.Context.PWD && filepath.Ext(file) == ".go"
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashDeps writes to the hash the versions of the current package, the
+// packages and every package they depend on, or their files if they have no
+// version, except the files skip reports true for.
+func (c *Craft) hashDeps(ctx context.Context, hash io.Writer, pkgs []string, skip func(dir, file string) bool) error {
+	args := append([]string{"list", "-e", "-deps", "-f", depsFormat, "."}, pkgs...)
+
+	deps, err := c.goCommandOutput(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(deps), "\n") {
+		pkg, files, _ := strings.Cut(line, "\t")
+		dir, files, _ := strings.Cut(files, "\t")
+
+		fmt.Fprintf(hash, "package %s\n", pkg)
+
+		// a package of a module without a version ends with an @ and its
+		// files are hashed instead
+		if !strings.HasSuffix(pkg, "@") {
+			continue
+		}
+
+		for _, file := range strings.Fields(files) {
+			if skip(dir, file) {
+				continue
+			}
+
+			if err := hashFile(hash, filepath.Join(dir, file)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// requestsCacheKey returns the key of the outputs of the requests of the
+// invocations in a cache entry. The key covers the executables of external
+// macros, which the host does not depend on.
+func requestsCacheKey(invocations []Invocation) (string, error) {
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+
+	for _, invocation := range invocations {
+		if err := encoder.Encode(invocation.Request); err != nil {
+			return "", err
+		}
+
+		if invocation.Exec == "" {
+			continue
+		}
+
+		if err := hashFile(hash, invocation.Exec); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// upToDate reports whether the outputs the program cached in the entry
+// generated for the requests with the key are still on disk as it wrote
+// them, so it needs not run again.
+func (c *Craft) upToDate(entry string, requestsKey string) bool {
+	outputsJSON, err := os.ReadFile(filepath.Join(entry, fmt.Sprintf(outputsFile, requestsKey)))
+	if err != nil {
+		return false
+	}
+
+	var outputs map[string]string
+
+	if err := json.Unmarshal(outputsJSON, &outputs); err != nil {
+		return false
+	}
+
+	for output, sum := range outputs {
+		if fileSum, err := fileSum(output); err != nil || fileSum != sum {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recordOutputs records the outputs the program cached in the entry
+// generated for the requests with the key.
+func (c *Craft) recordOutputs(entry string, requestsKey string, outputs []string) error {
+	sums := make(map[string]string, len(outputs))
+
+	for _, output := range outputs {
+		sum, err := fileSum(output)
+		if err != nil {
+			return err
+		}
+
+		sums[output] = sum
+	}
+
+	outputsJSON, err := json.Marshal(sums)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(entry, fmt.Sprintf(outputsFile, requestsKey)), outputsJSON, 0o644)
+}
+
+// goCommandOutput runs the go command in the current directory and returns
+// its standard output.
+func (c *Craft) goCommandOutput(ctx context.Context, args ...string) ([]byte, error) {
+	var cmdOut, cmdErr bytes.Buffer
+
+	cmd := Command(ctx, "go", args...)
+	cmd.Dir = c.Context.PWD
+	cmd.Stdout = &cmdOut
+	cmd.Stderr = &cmdErr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go %s: %s: %s", args[0], err, strings.TrimSpace(cmdErr.String()))
+	}
+
+	return cmdOut.Bytes(), nil
+}
+
+func (c *Craft) verbosef(format string, args ...any) {
+	if c.Context.Verbose {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+func hashFile(hash io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fmt.Fprintf(hash, "file %s\n", path)
+
+	_, err = io.Copy(hash, file)
+
+	return err
+}
+
+func fileSum(path string) (string, error) {
+	hash := sha256.New()
+
+	if err := hashFile(hash, path); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeFileAtomic writes the file through a temporary file renamed over it,
+// so concurrent runs never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
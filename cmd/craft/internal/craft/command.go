@@ -0,0 +1,46 @@
+package craft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// waitDelay is how long a killed command has to close its output before
+// craft stops waiting for it.
+const waitDelay = time.Second
+
+// Command returns the command running name with args, killed along with
+// every process it started when ctx is done.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = waitDelay
+
+	killProcessGroup(cmd)
+
+	return cmd
+}
+
+// CanceledError is returned when a macro host is killed before answering the
+// request with ID, because it timed out or craft was interrupted.
+type CanceledError struct {
+	ID    int
+	Cause error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("request %d: %s", e.ID, e.Cause)
+}
+
+// cancelCause returns why ctx is done. An interruption cancels the context
+// without a cause.
+func cancelCause(ctx context.Context) error {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, context.Canceled) {
+		return errors.New("interrupted")
+	}
+
+	return cause
+}
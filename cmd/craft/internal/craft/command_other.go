@@ -0,0 +1,9 @@
+//go:build !unix
+
+package craft
+
+import "os/exec"
+
+// killProcessGroup leaves the command as is: without process groups only the
+// command itself is killed on cancellation.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package craft
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup starts the command in a process group of its own and
+// kills the whole group on cancellation, so the compiler and test binaries
+// the go command starts do not outlive it.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -1,13 +1,47 @@
 package craft
 
+import (
+	"strings"
+	"time"
+)
+
 type Context struct {
 	MacroPackageImports  map[string]string
 	GoFile               string
 	RelativePath         string
 	CurrentPkgImportPath string
 	PWD                  string
+	// CacheDir is the directory compiled programs are cached in, or empty
+	// if they are not cached.
+	CacheDir string
+	// Verbose prints the macros run and the cache statistics.
+	Verbose bool
+	// Jobs is the number of declarations parsed, macros checked and external
+	// macros run at a time.
+	Jobs int
+	// MacroTimeout is how long a macro may run before its host is killed, or
+	// zero for no limit.
+	MacroTimeout time.Duration
+	// Force runs every macro, even those whose outputs are up to date.
+	Force bool
+	// DryRun reports the stale outputs of the current file instead of
+	// removing them.
+	DryRun bool
+	// Check compares the outputs with the files on disk instead of writing
+	// them, and reports the differences and the stale outputs as drift.
+	Check bool
 }
 
 func (c *Context) PackageImport(pkg string) string {
 	return c.MacroPackageImports[pkg]
 }
+
+// ExecPrefix prefixes the path of the executable of an external macro
+// package, as in tsgen=exec:./tools/tsgen.
+const ExecPrefix = "exec:"
+
+// ExecMacro returns the path of the executable of the macro package as
+// given, and whether the package is an external macro package.
+func (c *Context) ExecMacro(pkg string) (string, bool) {
+	return strings.CutPrefix(c.PackageImport(pkg), ExecPrefix)
+}
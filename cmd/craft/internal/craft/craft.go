@@ -2,39 +2,80 @@ package craft
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"go/ast"
 	"go/printer"
 	"go/token"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
+	"unicode"
 
 	"github.com/aria3ppp/craft/cmd/craft/internal/comment"
 	craft_error "github.com/aria3ppp/craft/error"
 	craft_parser "github.com/aria3ppp/craft/parser"
+	"github.com/aria3ppp/craft/protocol"
+	"github.com/aria3ppp/craft/typedesc"
 
 	"github.com/alecthomas/participle/v2"
 )
 
+// outputSuffix is the suffix of the files generated by craft.
+const outputSuffix = ".crafted.go"
+
+// currentPkgImportAlias is the name programs running outside of the current
+// package import it with.
+const currentPkgImportAlias = "typepkg"
+
 type Craft struct {
 	Context        *Context
 	CurrentASTFile *ast.File
+	CurrentSource  []byte
 	FileSet        *token.FileSet
 	Parser         *participle.Parser[craft_parser.MacroAST]
 
 	// nil is a valid default value for the following fields
 
-	Processes []Process
-	Errs      []craft_error.Error
+	Processes  []Process
+	Errs       []craft_error.Error
+	CacheStats CacheStats
+	// Drift counts the outputs differing from the files on disk, missing or
+	// stale in check mode.
+	Drift atomic.Int64
 
 	processesMu sync.Mutex
 	errsMu      sync.Mutex
+
+	macroPackages   map[string]*macroPackage
+	macroPackagesMu sync.Mutex
+
+	fields   map[*ast.TypeSpec][]Field
+	fieldsMu sync.Mutex
+
+	currentPackage     *currentPackage
+	currentPackageOnce sync.Once
+
+	sourcesHashValue string
+	sourcesHashOnce  sync.Once
+
+	// hidden are the stale outputs of the current file, which are left out
+	// of the build of the host
+	hidden []string
+
+	// generated are the paths of the files the macros generated, whether
+	// they were written or compared in check mode
+	generated   map[string]bool
+	generatedMu sync.Mutex
 }
 
 func (c *Craft) HandleMacrosOnSpec(
@@ -53,18 +94,8 @@ func (c *Craft) HandleMacrosOnTypeSpec(
 	genDecl *ast.GenDecl,
 	spec *ast.TypeSpec,
 ) {
-	switch spec.Type.(type) {
-	case *ast.InterfaceType:
-		return
-	}
-
 	typeName := spec.Name.Name
 
-	// skip if type name is not exported
-	if !token.IsExported(typeName) {
-		return
-	}
-
 	var (
 		doc     = genDecl.Doc
 		specPos = spec.Pos()
@@ -78,39 +109,58 @@ func (c *Craft) HandleMacrosOnTypeSpec(
 		return
 	}
 
-	c.HandleMacroOnSource(
-		typeName,
-		"",
-		specPos,
-		doc.List,
-	)
+	sourcePosition := c.FileSet.Position(specPos)
+
+	process := Process{
+		SourceName:     typeName,
+		SourcePosition: sourcePosition,
+		Source:         c.SpecSource(genDecl, spec, doc),
+		Generic:        spec.TypeParams != nil,
+		InPackage:      c.InPackage(typeName),
+	}
+
+	c.HandleMacroOnSource(process, doc.List, spec)
 }
 
 func (c *Craft) HandleMacrosOnValueSpec(
 	genDecl *ast.GenDecl,
 	spec *ast.ValueSpec,
 ) {
-	var typeName string
+	var (
+		typeName      string
+		instantiation bool
+	)
 
 	switch typ := spec.Type.(type) {
 	default:
 		return
 	case *ast.Ident:
 		typeName = typ.Name
-	}
 
-	// skip if type is exported
-	if token.IsExported(typeName) {
-		return
+		// skip if type is exported
+		if token.IsExported(typeName) {
+			return
+		}
+	case *ast.IndexExpr:
+		// an instantiation of a generic type
+		ident, ok := typ.X.(*ast.Ident)
+		if !ok {
+			return
+		}
+		typeName = ident.Name
+		instantiation = true
+	case *ast.IndexListExpr:
+		// an instantiation of a generic type
+		ident, ok := typ.X.(*ast.Ident)
+		if !ok {
+			return
+		}
+		typeName = ident.Name
+		instantiation = true
 	}
 
 	varName := spec.Names[0].Name
 
-	// skip if var name is not exported
-	if !token.IsExported(varName) {
-		return
-	}
-
 	var (
 		doc     = genDecl.Doc
 		specPos = spec.Pos()
@@ -124,35 +174,167 @@ func (c *Craft) HandleMacrosOnValueSpec(
 		return
 	}
 
+	var (
+		sourcePosition = c.FileSet.Position(specPos)
+		source         string
+	)
+
+	typeGenDecl, typeSpec := c.lookupTypeSpec(typeName)
+	if typeSpec != nil {
+		source = c.SpecSource(typeGenDecl, typeSpec, typeSpecDoc(typeGenDecl, typeSpec))
+	}
+
+	process := Process{
+		SourceName:     varName,
+		VarTypeName:    typeName,
+		SourcePosition: sourcePosition,
+		Source:         source,
+		Instantiation:  instantiation,
+		Const:          genDecl.Tok == token.CONST,
+		InPackage:      c.InPackage(varName),
+	}
+
+	c.HandleMacroOnSource(process, doc.List, typeSpec)
+}
+
+func (c *Craft) HandleMacrosOnFuncDecl(
+	funcDecl *ast.FuncDecl,
+) {
+	doc := funcDecl.Doc
+
+	if doc == nil || len(doc.List) == 0 {
+		return
+	}
+
+	var (
+		funcName        = funcDecl.Name.Name
+		receiver        string
+		pointerReceiver bool
+		generic         = funcDecl.Type.TypeParams != nil
+		inPackage       = c.InPackage(funcName)
+	)
+
+	if funcDecl.Recv != nil && len(funcDecl.Recv.List) == 1 {
+		recvType := funcDecl.Recv.List[0].Type
+
+		if star, ok := recvType.(*ast.StarExpr); ok {
+			recvType = star.X
+			pointerReceiver = true
+		}
+
+		switch typ := recvType.(type) {
+		default:
+			return
+		case *ast.Ident:
+			receiver = typ.Name
+		case *ast.IndexExpr:
+			// a method of a generic type
+			ident, ok := typ.X.(*ast.Ident)
+			if !ok {
+				return
+			}
+			receiver = ident.Name
+			generic = true
+		case *ast.IndexListExpr:
+			// a method of a generic type
+			ident, ok := typ.X.(*ast.Ident)
+			if !ok {
+				return
+			}
+			receiver = ident.Name
+			generic = true
+		}
+
+		inPackage = inPackage || c.InPackage(receiver)
+	}
+
+	// init and the main function of package main can not be referred to, so
+	// there is no value to reflect on
+	if funcDecl.Recv == nil && (funcName == "init" || (funcName == "main" && c.CurrentASTFile.Name.Name == "main")) {
+		sourcePosition := c.FileSet.Position(funcDecl.Name.Pos())
+
+		for _, macro := range c.ParseMacros(doc.List, sourcePosition, false) {
+			c.addError(
+				craft_error.Error{
+					Msg:            fmt.Sprintf("macro %q can not run on func %s, which can not be referred to", macro.AST.Macro, funcName),
+					RelativePath:   c.Context.RelativePath,
+					GoFile:         c.Context.GoFile,
+					MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
+					SourcePosition: craft_error.PositionFromToken(sourcePosition),
+				},
+			)
+		}
+
+		return
+	}
+
 	c.HandleMacroOnSource(
-		varName,
-		typeName,
-		specPos,
+		Process{
+			SourceName:      funcName,
+			SourcePosition:  c.FileSet.Position(funcDecl.Name.Pos()),
+			Source:          c.nodeSource(doc.Pos(), funcDecl.End(), ""),
+			Generic:         generic,
+			InPackage:       inPackage,
+			Func:            true,
+			Receiver:        receiver,
+			PointerReceiver: pointerReceiver,
+		},
 		doc.List,
+		nil,
 	)
 }
 
 // TODO: is 'HandleMacro' a good name?
 func (c *Craft) HandleMacroOnSource(
-	sourceName string,
-	unexportedTypeName string,
-	sourcePos token.Pos,
+	process Process,
 	comments []*ast.Comment,
+	typeSpec *ast.TypeSpec,
 ) {
-	iter := comment.Iter{
-		Comments: comments,
+	process.Macros = c.ParseMacros(comments, process.SourcePosition, false)
+
+	if len(process.Macros) == 0 {
+		return
+	}
+
+	// the field macros of a type are only parsed for declarations with
+	// macros, which they are passed to
+	if typeSpec != nil {
+		process.Fields = c.ParseFieldMacros(typeSpec)
 	}
 
-	sourcePosition := c.FileSet.Position(sourcePos)
+	// the constants of a type are looked up in the whole package, so only
+	// for declarations with macros
+	if typeName := constsTypeName(process); typeName != "" && !c.lookupConsts(&process, typeName) {
+		return
+	}
 
-	process := Process{
-		// GenDecl:      genDecl,
-		// Spec:         spec,
-		// TypePosition: typePosition,
-		SourceName:         sourceName,
-		UnexportedTypeName: unexportedTypeName,
-		SourcePosition:     sourcePosition,
-		Macros:             nil,
+	c.addProcess(process)
+}
+
+// constsTypeName returns the name of the type whose constants are passed to
+// the macros of the process, or an empty string if there is none: functions
+// have no constants, and neither do generic types nor their instantiations.
+func constsTypeName(process Process) string {
+	switch {
+	case process.Func, process.Generic, process.Instantiation:
+		return ""
+	case process.VarTypeName != "":
+		return process.VarTypeName
+	default:
+		return process.SourceName
+	}
+}
+
+// ParseMacros parses the macro invocations in the comments and reports the
+// invalid ones. Field macros are only passed to the macros of their struct
+// type and never run, so their package does not have to be defined.
+func (c *Craft) ParseMacros(
+	comments []*ast.Comment,
+	sourcePosition token.Position,
+	field bool,
+) (macros []*Macro) {
+	iter := comment.Iter{
+		Comments: comments,
 	}
 
 	for comment := iter.Next(); comment != nil; comment = iter.Next() {
@@ -165,7 +347,7 @@ func (c *Craft) HandleMacroOnSource(
 				errors.As(err, &participleError) // SAFETY: participle errors are all participle.Error
 
 				participleErrorPosition := participleError.Position()
-				macroErrorPosition := c.FileSet.Position(token.Pos(commentPosition.Offset) + 1 + token.Pos(participleErrorPosition.Offset))
+				macroErrorPosition := c.commentPosition(commentPosition, participleErrorPosition.Offset)
 
 				c.addError(
 					craft_error.Error{
@@ -181,9 +363,9 @@ func (c *Craft) HandleMacroOnSource(
 			continue
 		}
 
-		if c.Context.PackageImport(macroAST.Package) == "" {
+		if !field && c.Context.PackageImport(macroAST.Package) == "" {
 			pkgIndex := strings.Index(comment.Text, macroAST.Package)
-			macroErrorPosition := c.FileSet.Position(token.Pos(commentPosition.Offset) + 1 + token.Pos(pkgIndex))
+			macroErrorPosition := c.commentPosition(commentPosition, pkgIndex)
 
 			c.addError(
 				craft_error.Error{
@@ -200,7 +382,7 @@ func (c *Craft) HandleMacroOnSource(
 
 		if !token.IsExported(macroAST.Macro) {
 			macroIndex := strings.Index(comment.Text, "."+macroAST.Macro)
-			macroErrorPosition := c.FileSet.Position(token.Pos(commentPosition.Offset) + 1 + token.Pos(macroIndex) + 1)
+			macroErrorPosition := c.commentPosition(commentPosition, macroIndex+1)
 
 			c.addError(
 				craft_error.Error{
@@ -215,205 +397,1005 @@ func (c *Craft) HandleMacroOnSource(
 			continue
 		}
 
+		input, ok := c.MacroInput(macroAST, commentPosition, sourcePosition)
+		if !ok {
+			continue
+		}
+
 		poundIndex := strings.Index(comment.Text, "#")
-		macroPosition := c.FileSet.Position(token.Pos(commentPosition.Offset) + 1 + token.Pos(poundIndex))
+		macroPosition := c.commentPosition(commentPosition, poundIndex)
 
-		process.Macros = append(
-			process.Macros,
+		macros = append(
+			macros,
 			&Macro{
 				AST:           macroAST,
+				Input:         input,
+				Text:          strings.TrimSpace(comment.Text[poundIndex+1:]),
 				MacroPosition: macroPosition,
 			},
 		)
 	}
 
-	if len(process.Macros) > 0 {
-		c.addProcess(process)
-	}
+	return macros
 }
 
-func (c *Craft) HandleProcess(
-	process Process,
-) {
-	// DEBUG: remove this
-	{
+// HandleProcesses runs every macro of the processes of the current file
+// through one macro host, so the file pays for a single compile. Errors are
+// still reported at the position of each macro. The stale outputs of the
+// file are hidden from the build of the host. It reports false if a macro
+// failed.
+func (c *Craft) HandleProcesses(
+	ctx context.Context,
+	processes []Process,
+	stale []string,
+) (ok bool) {
+	c.hidden = stale
+
+	slices.SortFunc(processes, func(p1, p2 Process) int {
+		return p1.SourcePosition.Offset - p2.SourcePosition.Offset
+	})
+
+	if c.Context.Verbose {
 		fp := filepath.Join(c.Context.RelativePath, c.Context.GoFile)
-		fmt.Printf("%s:%d:%d:\n", fp, process.SourcePosition.Line, process.SourcePosition.Column)
 
-		for _, m := range process.Macros {
-			fmt.Printf("\t%s:%d:%d: %s.%s(`%s`)\n", fp, m.MacroPosition.Line, m.MacroPosition.Column, m.AST.Package, m.AST.Macro, m.AST.Input)
+		for _, process := range processes {
+			fmt.Printf("%s:%d:%d:\n", fp, process.SourcePosition.Line, process.SourcePosition.Column)
+
+			for _, m := range process.Macros {
+				fmt.Printf("\t%s:%d:%d: %s\n", fp, m.MacroPosition.Line, m.MacroPosition.Column, invocationText(m))
+			}
+		}
+	}
+
+	var checked []Invocation
+
+	for _, process := range processes {
+		for _, macro := range process.Macros {
+			checked = append(checked, Invocation{Process: process, Macro: macro})
+		}
+	}
+
+	// macros are checked concurrently and reported in order
+	var (
+		errs = make([]error, len(checked))
+		pool = NewPool(c.Context.Jobs)
+	)
+
+	for i := range checked {
+		pool.Go(func() {
+			checked[i], errs[i] = c.Invocation(ctx, checked[i].Process, checked[i].Macro)
+		})
+	}
+
+	pool.Wait()
+
+	var (
+		invocations []Invocation
+		// whether any macro is not fresh
+		run bool
+	)
+
+	ok = true
+
+	for i, err := range errs {
+		if err != nil {
+			fmt.Println(err.Error())
+
+			ok = false
+
+			continue
+		}
+
+		invocations = append(invocations, checked[i])
+
+		if checked[i].Fresh {
+			c.CacheStats.Fresh.Add(1)
+			c.verbosef("fresh: %s.%s on %s", checked[i].Macro.AST.Package, checked[i].Macro.AST.Macro, checked[i].Request.Declaration.Name)
+		} else {
+			run = true
 		}
 	}
 
-	for _, macro := range process.Macros {
-		c.GenerateProgram(process, macro)
+	// every invocation runs in-package if any of them has to
+	inPackage := slices.ContainsFunc(invocations, invocationInPackage)
+
+	if run {
+		ok = c.GenerateProgram(ctx, invocations, inPackage) && ok
 	}
+
+	return ok
 }
 
-func (c *Craft) GenerateProgram(
+// Invocation checks the macro and returns the request running it on the
+// process. It returns a craft_error.Error if the macro can not run on the
+// process.
+func (c *Craft) Invocation(
+	ctx context.Context,
 	process Process,
 	macro *Macro,
-) {
+) (Invocation, error) {
 	typeName := process.SourceName
 
-	if process.UnexportedTypeName != "" {
-		typeName = process.UnexportedTypeName
+	if process.VarTypeName != "" {
+		typeName = process.VarTypeName
 	}
 
-	dirname := strings.ToLower(fmt.Sprintf("%d_%s_%s_%s", time.Now().UnixNano(), typeName, macro.AST.Package, macro.AST.Macro))
-	dirPath := filepath.Join(c.Context.PWD, dirname)
+	if process.Receiver != "" {
+		typeName = process.Receiver
+	}
 
-	if err := os.Mkdir(dirPath, 0o755); err != nil {
-		fmt.Println(craft_error.Error{
-			Msg:            fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to mkdir %s: %s", dirPath, err.Error()),
+	if execPath, isExec := c.Context.ExecMacro(macro.AST.Package); isExec {
+		return c.execInvocation(process, macro, typeName, execPath)
+	}
+
+	macroFunc, err := c.CheckMacro(ctx, macro)
+	if err != nil {
+		return Invocation{}, craft_error.Error{
+			Msg:            err.Error(),
 			RelativePath:   c.Context.RelativePath,
 			GoFile:         c.Context.GoFile,
 			MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
 			SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
-		}.Error())
+		}
+	}
 
-		return
+	if process.Generic && macroFunc.Type == MacroTypeReflect {
+		return Invocation{}, craft_error.Error{
+			Msg:            fmt.Sprintf("macro %q takes a reflect.Type which generic declaration %s does not have; take a macro.TypeData or instantiate the type with a variable", macro.AST.Macro, process.SourceName),
+			RelativePath:   c.Context.RelativePath,
+			GoFile:         c.Context.GoFile,
+			MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
+			SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
+		}
 	}
 
-	defer func() {
-		if err := os.RemoveAll(dirPath); err != nil {
-			fmt.Println(craft_error.Error{
-				Msg:            fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to remove dir %s: %s", dirPath, err.Error()),
-				RelativePath:   c.Context.RelativePath,
-				GoFile:         c.Context.GoFile,
-				MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
-				SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
-			}.Error())
+	// the package is loaded by CheckMacro
+	pkg, _ := c.loadMacroPackage(ctx, c.Context.PackageImport(macro.AST.Package))
+
+	invocation := Invocation{
+		Process:      process,
+		Macro:        macro,
+		Request:      c.request(process, macro, typeName, macroFunc.Type == MacroTypeData),
+		MacroVersion: pkg.version,
+	}
+
+	invocation.Hash = c.inputsHash(ctx, invocation)
+	invocation.Fresh = c.fresh(invocation)
+
+	return invocation, nil
+}
+
+// execInvocation returns the invocation of an external macro, whose
+// executable is looked up in the current directory, or in PATH if the path
+// is a bare name. External macros always get the source data.
+func (c *Craft) execInvocation(
+	process Process,
+	macro *Macro,
+	typeName string,
+	execPath string,
+) (Invocation, error) {
+	var (
+		path = execPath
+		err  error
+	)
+
+	if !strings.ContainsRune(execPath, filepath.Separator) && !strings.ContainsRune(execPath, '/') {
+		path, err = exec.LookPath(execPath)
+	} else {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.Context.PWD, path)
 		}
-	}()
 
-	tmplt, err := template.New("").Parse(programTemplate)
+		_, err = os.Stat(path)
+	}
+
 	if err != nil {
-		fmt.Println(craft_error.Error{
-			Msg:            fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to template.New: %s", err.Error()),
+		return Invocation{}, craft_error.Error{
+			Msg:            fmt.Sprintf("external macro package %q: %s", macro.AST.Package, err),
 			RelativePath:   c.Context.RelativePath,
 			GoFile:         c.Context.GoFile,
 			MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
 			SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
-		}.Error())
+		}
+	}
 
-		return
+	request := c.request(process, macro, typeName, true)
+	request.Package = execPath
+
+	invocation := Invocation{
+		Process: process,
+		Macro:   macro,
+		Request: request,
+		Exec:    path,
 	}
 
-	bytesBuffer := bytes.NewBuffer(make([]byte, 0, len(programTemplate)))
-	outputFilePath := filepath.Join(
-		c.Context.RelativePath,
-		fmt.Sprintf("%s_%s_%s.crafted.go", typeName, macro.AST.Package, macro.AST.Macro),
-	)
+	if sum, err := fileSum(path); err == nil {
+		invocation.MacroVersion = "sha256:" + sum
+	}
 
-	var valueDefinition string
+	return invocation, nil
+}
+
+// request returns the request running the macro on the declaration of the
+// process. The source data of the declaration is only sent if typeData is
+// true.
+func (c *Craft) request(
+	process Process,
+	macro *Macro,
+	typeName string,
+	typeData bool,
+) protocol.Request {
+	declarationName := process.SourceName
 
-	if process.UnexportedTypeName != "" {
-		valueDefinition = "= "
+	if process.Receiver != "" {
+		declarationName = process.Receiver + "." + process.SourceName
 	}
 
-	currentPkgImportAlias := "typepkg"
-	valueDefinition += fmt.Sprintf("%s.%s", currentPkgImportAlias, process.SourceName)
-	pkgImportPathDefinition := fmt.Sprintf("%s \"%s\"", currentPkgImportAlias, c.Context.CurrentPkgImportPath)
+	pkgPath := c.Context.CurrentPkgImportPath
 
-	data := TemplateDate{
-		OutputFilePath:  outputFilePath,
-		ValueDefinition: valueDefinition,
-		SourceName:      process.SourceName,
-		TypeName:        typeName,
-		SourcePosition:  craft_error.PositionFromToken(process.SourcePosition),
-		Macro: TemplateDataMacro{
-			Name:       macro.AST.Macro,
-			ImportPath: c.Context.PackageImport(macro.AST.Package),
-			Input:      macro.AST.Input,
-			Position:   craft_error.PositionFromToken(macro.MacroPosition),
-		},
-		Package: TemplateDataPackage{
-			RelativePath:         c.Context.RelativePath,
-			GoFile:               c.Context.GoFile,
-			ImportPathDefinition: pkgImportPathDefinition,
-			Name:                 c.CurrentASTFile.Name.Name,
-		},
+	// reflection reports the path of a main package as main
+	if c.CurrentASTFile.Name.Name == "main" {
+		pkgPath = "main"
 	}
 
+	declaration := protocol.Declaration{
+		Name:     declarationName,
+		TypeName: typeName,
+		Package:  c.CurrentASTFile.Name.Name,
+		PkgPath:  pkgPath,
+		Filename: filepath.ToSlash(filepath.Join(c.Context.RelativePath, c.Context.GoFile)),
+		Func:     process.Func,
+		Generic:  process.Generic,
+	}
+
+	if typeData {
+		declaration.Source = process.Source
+		declaration.Fields = c.macroFields(process.Fields)
+		declaration.Consts = c.protocolConsts(process.Consts)
+	}
+
+	return protocol.Request{
+		Version:     protocol.Version,
+		Package:     c.Context.PackageImport(macro.AST.Package),
+		Macro:       macro.AST.Macro,
+		Input:       macro.Input,
+		Declaration: declaration,
+		Output:      outputFile(process, macro),
+	}
+}
+
+// outputFile returns the name of the file the macro generates for the
+// declaration of the process.
+func outputFile(process Process, macro *Macro) string {
+	outputName := process.SourceName
+
+	switch {
+	// instantiations of the same generic type are told apart by variable
+	case process.Instantiation:
+		outputName = process.SourceName
+	case process.Receiver != "":
+		outputName = process.Receiver + "_" + process.SourceName
+	case process.VarTypeName != "":
+		outputName = process.VarTypeName
+	}
+
+	return fmt.Sprintf("%s_%s_%s%s", outputName, macro.AST.Package, macro.AST.Macro, outputSuffix)
+}
+
+// GenerateProgram generates the macro host of the invocations, then runs
+// it and the external macros and writes the files they generate. Errors of
+// the whole host, e.g. failing to compile it, are reported at the position
+// of the first macro. It reports false if a macro failed.
+func (c *Craft) GenerateProgram(
+	ctx context.Context,
+	invocations []Invocation,
+	inPackage bool,
+) bool {
+	first := craft_error.PositionFromToken(invocations[0].Macro.MacroPosition)
+
+	tmplt, err := template.New("").Parse(programTemplate)
+	if err != nil {
+		fmt.Println(craft_error.Error{
+			Msg:           fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to template.New: %s", err.Error()),
+			RelativePath:  c.Context.RelativePath,
+			GoFile:        c.Context.GoFile,
+			MacroPosition: first,
+			Kind:          craft_error.KindProgram,
+		}.Error())
+
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(c.Context.GoFile, ".go"))
+
+	data := c.templateData(invocations, inPackage)
+	// the test name is part of the program source, so it must not change
+	// between runs for the program to be found in the cache
+	data.TestName = "TestCraft_" + strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, name)
+
+	bytesBuffer := bytes.NewBuffer(make([]byte, 0, len(programTemplate)))
+
 	if err = tmplt.Execute(bytesBuffer, data); err != nil {
 		fmt.Println(craft_error.Error{
-			Msg:            fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to template.Execute: %s", err),
-			RelativePath:   c.Context.RelativePath,
-			GoFile:         c.Context.GoFile,
-			MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
-			SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
+			Msg:           fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to template.Execute: %s", err),
+			RelativePath:  c.Context.RelativePath,
+			GoFile:        c.Context.GoFile,
+			MacroPosition: first,
+			Kind:          craft_error.KindProgram,
 		}.Error())
 
-		return
+		return false
 	}
 
-	programPath := filepath.Join(dirPath, "program.go")
+	var cacheEntry, requestsKey string
+
+	if c.Context.CacheDir != "" {
+		key, err := c.cacheKey(ctx, bytesBuffer.Bytes(), inPackage, invocations)
+		if err == nil {
+			requestsKey, err = requestsCacheKey(invocations)
+		}
+
+		if err != nil {
+			c.verbosef("cache: disabled for %s: %s", c.Context.GoFile, err)
+		} else {
+			cacheEntry = filepath.Join(c.Context.CacheDir, key)
+		}
+	}
 
-	programFile, err := os.Create(programPath)
+	if cacheEntry != "" {
+		touchCacheEntry(cacheEntry)
+	}
+
+	if cacheEntry != "" && !c.Context.Force && c.upToDate(cacheEntry, requestsKey) {
+		c.CacheStats.UpToDate.Add(1)
+		c.verbosef("cache: %s is up to date", c.Context.GoFile)
+
+		return true
+	}
+
+	// the program is built out of the source tree, so nothing is left in it
+	// if craft is killed
+	dirPath, err := os.MkdirTemp("", "craft-"+name+"-")
 	if err != nil {
 		fmt.Println(craft_error.Error{
-			Msg:            fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to create %s: %s", programPath, err.Error()),
-			RelativePath:   c.Context.RelativePath,
-			GoFile:         c.Context.GoFile,
-			MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
-			SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
+			Msg:           fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to create a temporary directory: %s", err.Error()),
+			RelativePath:  c.Context.RelativePath,
+			GoFile:        c.Context.GoFile,
+			MacroPosition: first,
+			Kind:          craft_error.KindProgram,
 		}.Error())
 
-		return
+		return false
 	}
 
 	defer func() {
-		if err := programFile.Close(); err != nil {
+		if err := os.RemoveAll(dirPath); err != nil {
 			fmt.Println(craft_error.Error{
-				Msg:            fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to close %s: %s", programPath, err.Error()),
-				RelativePath:   c.Context.RelativePath,
-				GoFile:         c.Context.GoFile,
-				MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
-				SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
+				Msg:           fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to remove dir %s: %s", dirPath, err.Error()),
+				RelativePath:  c.Context.RelativePath,
+				GoFile:        c.Context.GoFile,
+				MacroPosition: first,
+				Kind:          craft_error.KindProgram,
 			}.Error())
 		}
 	}()
 
-	if _, err := programFile.Write(bytesBuffer.Bytes()); err != nil {
+	programBinaryPath := filepath.Join(dirPath, "program")
+	build := true
+
+	if cacheEntry != "" {
+		programBinaryPath = filepath.Join(cacheEntry, "program")
+
+		if _, err := os.Stat(programBinaryPath); err == nil {
+			build = false
+
+			c.CacheStats.Hits.Add(1)
+			c.verbosef("cache: hit for %s", c.Context.GoFile)
+		} else {
+			c.CacheStats.Misses.Add(1)
+			c.verbosef("cache: miss for %s", c.Context.GoFile)
+		}
+	}
+
+	if build && !c.buildProgram(ctx, bytesBuffer.Bytes(), dirPath, programBinaryPath, inPackage, first) {
+		return false
+	}
+
+	var hostArgs []string
+
+	if inPackage {
+		hostArgs = []string{"-test.run", "^" + data.TestName + "$"}
+	}
+
+	hostRequests, hostInvocations := hostRequests(invocations)
+
+	responses, err := c.RunHost(ctx, programBinaryPath, hostArgs, hostRequests)
+
+	// the host is generated by craft, so it always speaks the protocol
+	var responseErr *ResponseError
+	if errors.As(err, &responseErr) {
+		err = fmt.Errorf("[INTERNAL ERROR] [file a bug] macro host: %w", err)
+	}
+
+	// the responses received before a macro was canceled are still handled,
+	// so one slow macro does not discard the outputs of the others
+	if err != nil {
+		c.printHostError(err, hostInvocations)
+	}
+
+	var (
+		outputs     = make([]string, 0, len(invocations))
+		failed      = err != nil
+		descriptors = make(map[string]*typedesc.Descriptor)
+	)
+
+	for _, invocation := range invocations {
+		if invocation.Fresh {
+			outputs = append(outputs, filepath.Join(c.Context.PWD, invocation.Request.Output))
+		}
+	}
+
+	for i, response := range responses {
+		written, ok := c.HandleResponse(hostInvocations[i], response)
+
+		if hostRequests[i].Describe && ok {
+			descriptors[hostRequests[i].Declaration.Name] = response.Descriptor
+		}
+
+		outputs = append(outputs, written...)
+		failed = failed || !ok
+	}
+
+	// an interrupted run does not start the external macros, which are
+	// reported as not run unless printHostError reported them
+	if ctx.Err() != nil {
+		var (
+			canceledErr *CanceledError
+			reported    = make(map[*Macro]bool)
+		)
+
+		if errors.As(err, &canceledErr) {
+			for _, invocation := range hostInvocations[canceledErr.ID:] {
+				reported[invocation.Macro] = true
+			}
+		}
+
+		for _, invocation := range invocations {
+			if invocation.Exec != "" && !reported[invocation.Macro] {
+				c.printNotRun(invocation, cancelCause(ctx))
+			}
+		}
+
+		return false
+	}
+
+	written, ok := c.RunExecMacros(ctx, invocations, descriptors)
+
+	outputs = append(outputs, written...)
+	failed = failed || !ok
+
+	// nothing is written in check mode, so the files on disk may differ
+	if cacheEntry != "" && !failed && !c.Context.Check {
+		if err := c.recordOutputs(cacheEntry, requestsKey, outputs); err != nil {
+			c.verbosef("cache: failed to record the outputs of %s: %s", c.Context.GoFile, err)
+		}
+	}
+
+	return !failed
+}
+
+// HandleResponse prints the diagnostics of the response at the position of
+// the macro of the invocation and writes the files of the response. It
+// returns the paths of the written files and reports false if the macro
+// failed.
+func (c *Craft) HandleResponse(invocation Invocation, response protocol.Response) (written []string, ok bool) {
+	ok = true
+
+	for _, diagnostic := range response.Diagnostics {
+		position := invocation.Macro.MacroPosition
+
+		if diagnostic.Position != nil {
+			position = *diagnostic.Position
+		}
+
 		fmt.Println(craft_error.Error{
-			Msg:            fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to write the program: %s", err),
-			RelativePath:   c.Context.RelativePath,
-			GoFile:         c.Context.GoFile,
-			MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
-			SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
+			Msg:           diagnostic.Message,
+			RelativePath:  c.Context.RelativePath,
+			GoFile:        c.Context.GoFile,
+			MacroPosition: craft_error.PositionFromToken(position),
+			Kind:          craft_error.KindProgram,
 		}.Error())
 
+		ok = false
+	}
+
+	for _, file := range response.Files {
+		content := file.Content
+
+		path, err := c.outputPath(file.Path)
+		if err != nil {
+			fmt.Println(craft_error.Error{
+				Msg:           fmt.Sprintf("failed to write the output of macro %s: %s", invocation.Macro.AST.Macro, err),
+				RelativePath:  c.Context.RelativePath,
+				GoFile:        c.Context.GoFile,
+				MacroPosition: craft_error.PositionFromToken(invocation.Macro.MacroPosition),
+				Kind:          craft_error.KindProgram,
+			}.Error())
+
+			ok = false
+
+			continue
+		}
+
+		// an invalid file is not written, so it is reported here rather
+		// than when the package fails to compile
+		if strings.HasSuffix(path, ".go") {
+			content, err = formatOutput(path, file.Path, content)
+			if err != nil {
+				fmt.Println(craft_error.Error{
+					Msg:           fmt.Sprintf("macro %s generated invalid Go: %s", invocation.Macro.AST.Macro, err),
+					RelativePath:  c.Context.RelativePath,
+					GoFile:        c.Context.GoFile,
+					MacroPosition: craft_error.PositionFromToken(invocation.Macro.MacroPosition),
+					Kind:          craft_error.KindProgram,
+				}.Error())
+
+				ok = false
+
+				continue
+			}
+
+			// the header is added once the file is valid, so the lines of
+			// syntax errors are those of the macro output. Only the output
+			// named by craft records the hash of the inputs, as the other
+			// files of external macros are not known before they run.
+			header := c.invocationHeader(invocation)
+
+			if file.Path != invocation.Request.Output {
+				header.Hash = ""
+			}
+
+			content = header.String() + "\n" + content
+		}
+
+		if err := c.writeOutput(path, content); err != nil {
+			fmt.Println(craft_error.Error{
+				Msg:           fmt.Sprintf("failed to write the output of macro %s: %s", invocation.Macro.AST.Macro, err),
+				RelativePath:  c.Context.RelativePath,
+				GoFile:        c.Context.GoFile,
+				MacroPosition: craft_error.PositionFromToken(invocation.Macro.MacroPosition),
+				Kind:          craft_error.KindProgram,
+			}.Error())
+
+			ok = false
+
+			continue
+		}
+
+		written = append(written, path)
+	}
+
+	return written, ok
+}
+
+// printHostError prints the error of a macro host running the requests of
+// the invocations. A host killed while running a macro is reported at the
+// position of that macro, and the macros after it at theirs as not run. Any
+// other error is reported at the position of the first macro.
+func (c *Craft) printHostError(err error, invocations []Invocation) {
+	var canceledErr *CanceledError
+
+	if !errors.As(err, &canceledErr) {
+		c.printMacroError(invocations[0].Macro, err.Error())
 		return
 	}
 
-	var goRunCmdOut bytes.Buffer
+	canceled := invocations[canceledErr.ID]
+
+	c.printMacroError(canceled.Macro, fmt.Sprintf("macro %s on %s: %s", canceled.Macro.AST.Macro, canceled.Request.Declaration.Name, canceledErr.Cause))
+
+	for _, invocation := range invocations[canceledErr.ID+1:] {
+		c.printNotRun(invocation, canceledErr.Cause)
+	}
+}
+
+// printNotRun prints that the macro of the invocation did not run because
+// the run was canceled.
+func (c *Craft) printNotRun(invocation Invocation, cause error) {
+	macro := invocation.Macro
+
+	c.printMacroError(macro, fmt.Sprintf("macro %s on %s: not run: %s", macro.AST.Macro, invocation.Request.Declaration.Name, cause))
+}
+
+// printMacroError prints the error at the position of the macro.
+func (c *Craft) printMacroError(macro *Macro, msg string) {
+	fmt.Println(craft_error.Error{
+		Msg:           msg,
+		RelativePath:  c.Context.RelativePath,
+		GoFile:        c.Context.GoFile,
+		MacroPosition: craft_error.PositionFromToken(macro.MacroPosition),
+		Kind:          craft_error.KindProgram,
+	}.Error())
+}
 
-	goRunCmd := exec.Command("go", "run", ".")
-	goRunCmd.Dir = dirPath
-	goRunCmd.Stdout = &goRunCmdOut
-	goRunCmd.Stderr = &goRunCmdOut
+// writeOutput writes the generated file, or compares it with the file on
+// disk in check mode and prints the diff if they differ.
+func (c *Craft) writeOutput(path string, content string) error {
+	c.generatedMu.Lock()
+	if c.generated == nil {
+		c.generated = make(map[string]bool)
+	}
+	c.generated[path] = true
+	c.generatedMu.Unlock()
 
-	if err := goRunCmd.Run(); err != nil {
+	if !c.Context.Check {
+		return os.WriteFile(path, []byte(content), 0o666)
+	}
+
+	// outputPath only resolves paths in the current directory
+	rel, _ := filepath.Rel(c.Context.PWD, path)
+	relPath := filepath.Join(c.Context.RelativePath, rel)
+	oldName := "a/" + filepath.ToSlash(relPath)
+
+	current, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Printf("missing: %s\n", relPath)
+
+		oldName = "/dev/null"
+	} else if err != nil {
+		return err
+	}
+
+	if diff := unifiedDiff(oldName, "b/"+filepath.ToSlash(relPath), string(current), content); diff != "" {
+		c.Drift.Add(1)
+
+		fmt.Print(diff)
+	}
+
+	return nil
+}
+
+// outputPath resolves the path of a generated file against the current
+// directory. Macros may only generate files in the current directory or
+// below it.
+func (c *Craft) outputPath(path string) (string, error) {
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("output path %q is not local to %s", path, c.Context.PWD)
+	}
+
+	return filepath.Join(c.Context.PWD, path), nil
+}
+
+// buildProgram builds the program into the binary path. The program is
+// written to the temporary directory and overlaid on the current module, so
+// it resolves the module and its dependencies as if it were in it: in a
+// directory of its own, or as a test of the current package for in-package
+// programs. The hidden files of the current package are overlaid with
+// nothing, so they are left out of the build. It prints the error and
+// reports false if the program could not be built.
+func (c *Craft) buildProgram(
+	ctx context.Context,
+	program []byte,
+	dirPath string,
+	programBinaryPath string,
+	inPackage bool,
+	first craft_error.Position,
+) bool {
+	var (
+		programPath = filepath.Join(dirPath, "program.go")
+		overlayPath = filepath.Join(dirPath, "overlay.json")
+		// the directory of the program in the module never exists on disk
+		pkg         = "./" + filepath.Base(dirPath)
+		virtualPath = filepath.Join(c.Context.PWD, filepath.Base(dirPath), "program.go")
+	)
+
+	if inPackage {
+		pkg = "."
+		virtualPath = filepath.Join(c.Context.PWD, fmt.Sprintf("craft_%s_test.go", filepath.Base(dirPath)))
+	}
+
+	replace := map[string]string{virtualPath: programPath}
+
+	for _, path := range c.hidden {
+		replace[path] = ""
+	}
+
+	overlay, err := json.Marshal(map[string]any{
+		"Replace": replace,
+	})
+	if err == nil {
+		err = os.WriteFile(programPath, program, 0o644)
+	}
+	if err == nil {
+		err = os.WriteFile(overlayPath, overlay, 0o644)
+	}
+
+	if err != nil {
+		fmt.Println(craft_error.Error{
+			Msg:           fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to write the program: %s", err),
+			RelativePath:  c.Context.RelativePath,
+			GoFile:        c.Context.GoFile,
+			MacroPosition: first,
+			Kind:          craft_error.KindProgram,
+		}.Error())
+
+		return false
+	}
+
+	if err := os.MkdirAll(filepath.Dir(programBinaryPath), 0o755); err != nil {
+		fmt.Println(craft_error.Error{
+			Msg:           fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to mkdir %s: %s", filepath.Dir(programBinaryPath), err.Error()),
+			RelativePath:  c.Context.RelativePath,
+			GoFile:        c.Context.GoFile,
+			MacroPosition: first,
+			Kind:          craft_error.KindProgram,
+		}.Error())
+
+		return false
+	}
+
+	// the binary is built next to its final path and renamed, so concurrent
+	// runs sharing the cache never see a partially written binary
+	builtBinaryPath := fmt.Sprintf("%s.%d", programBinaryPath, time.Now().UnixNano())
+
+	buildCmd := Command(ctx, "go", "build", "-overlay", overlayPath, "-o", builtBinaryPath, pkg)
+
+	if inPackage {
+		buildCmd = Command(ctx, "go", "test", "-c", "-overlay", overlayPath, "-o", builtBinaryPath, pkg)
+	}
+
+	buildCmd.Dir = c.Context.PWD
+
+	var buildCmdOut bytes.Buffer
+
+	buildCmd.Stdout = &buildCmdOut
+	buildCmd.Stderr = &buildCmdOut
+
+	if err := buildCmd.Run(); err != nil {
 		var msg string
 
 		switch err.(type) {
 		default:
-			msg = fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to run the program: %s", err)
+			msg = fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to build the program: %s", err)
 		case *exec.ExitError:
-			msg = goRunCmdOut.String()
+			msg = buildCmdOut.String()
+		}
+
+		if ctx.Err() != nil {
+			msg = fmt.Sprintf("failed to build the program: %s", cancelCause(ctx))
 		}
 
 		fmt.Println(craft_error.Error{
-			Msg:            msg,
-			RelativePath:   c.Context.RelativePath,
-			GoFile:         c.Context.GoFile,
-			MacroPosition:  craft_error.PositionFromToken(macro.MacroPosition),
-			SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
+			Msg:           msg,
+			RelativePath:  c.Context.RelativePath,
+			GoFile:        c.Context.GoFile,
+			MacroPosition: first,
+			Kind:          craft_error.KindProgram,
 		}.Error())
 
-		return
+		return false
 	}
+
+	if err := os.Rename(builtBinaryPath, programBinaryPath); err != nil {
+		fmt.Println(craft_error.Error{
+			Msg:           fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to rename %s: %s", builtBinaryPath, err.Error()),
+			RelativePath:  c.Context.RelativePath,
+			GoFile:        c.Context.GoFile,
+			MacroPosition: first,
+			Kind:          craft_error.KindProgram,
+		}.Error())
+
+		return false
+	}
+
+	return true
+}
+
+// templateData returns the data of the macro host running the invocations.
+// The host only depends on the declarations and macros of the invocations,
+// not on their inputs. It registers the declarations of external macros too,
+// to describe them.
+func (c *Craft) templateData(invocations []Invocation, inPackage bool) TemplateDate {
+	data := TemplateDate{
+		InPackage: inPackage,
+		Package: TemplateDataPackage{
+			Name: c.CurrentASTFile.Name.Name,
+		},
+	}
+
+	var (
+		qualifier     = currentPkgImportAlias + "."
+		usesTypePkg   bool
+		aliases       = make(map[string]string)
+		macroPackages []string
+		macros        = make(map[string]bool)
+		declarations  = make(map[string]bool)
+		// the constants of a declaration are only evaluated if a Go macro
+		// taking a macro.TypeData runs on it
+		constsUsed = make(map[string]bool)
+	)
+
+	if inPackage {
+		qualifier = ""
+	}
+
+	for _, invocation := range invocations {
+		if invocation.Exec == "" && len(invocation.Request.Declaration.Consts) > 0 {
+			constsUsed[invocation.Request.Declaration.Name] = true
+		}
+	}
+
+	for _, invocation := range invocations {
+		importPath := invocation.Request.Package
+
+		if invocation.Exec != "" {
+			continue
+		}
+
+		if _, exists := aliases[importPath]; !exists {
+			aliases[importPath] = ""
+			macroPackages = append(macroPackages, importPath)
+		}
+	}
+
+	slices.Sort(macroPackages)
+
+	for i, importPath := range macroPackages {
+		alias := fmt.Sprintf("craft_macropkg%d", i)
+
+		aliases[importPath] = alias
+		data.MacroPackages = append(data.MacroPackages, TemplateDataMacroPackage{Alias: alias, ImportPath: importPath})
+	}
+
+	for _, invocation := range invocations {
+		request := invocation.Request
+
+		if key := request.Package + "." + request.Macro; invocation.Exec == "" && !macros[key] {
+			macros[key] = true
+
+			data.Macros = append(data.Macros, TemplateDataMacro{
+				Key:          key,
+				Name:         request.Macro,
+				PackageAlias: aliases[request.Package],
+			})
+		}
+
+		if declarations[request.Declaration.Name] {
+			continue
+		}
+
+		declarations[request.Declaration.Name] = true
+
+		declaration := TemplateDataDeclaration{
+			Name:       request.Declaration.Name,
+			TypeOfExpr: typeOfExpr(invocation.Process, qualifier),
+		}
+
+		if constsUsed[request.Declaration.Name] {
+			for _, constant := range invocation.Process.Consts {
+				declaration.Consts = append(declaration.Consts, TemplateDataConst{
+					Name: constant.Name,
+					Expr: qualifier + constant.Name,
+				})
+			}
+		}
+
+		usesTypePkg = usesTypePkg || declaration.TypeOfExpr != "" || len(declaration.Consts) > 0
+		data.UsesReflect = data.UsesReflect || declaration.TypeOfExpr != "" || len(declaration.Consts) > 0
+		data.Declarations = append(data.Declarations, declaration)
+	}
+
+	// the current package is only imported for side effects if no
+	// declaration refers to it, e.g. if every declaration is generic
+	pkgImportAlias := currentPkgImportAlias
+
+	if !usesTypePkg {
+		pkgImportAlias = "_"
+	}
+
+	data.Package.ImportPathDefinition = fmt.Sprintf("%s \"%s\"", pkgImportAlias, c.Context.CurrentPkgImportPath)
+
+	return data
+}
+
+// typeOfExpr returns the expression of the reflect.Type of the declaration
+// of the process, or an empty string for a generic declaration which has no
+// type to reflect on until it is instantiated.
+func typeOfExpr(process Process, qualifier string) string {
+	switch {
+	case process.Generic:
+		return ""
+	case process.Receiver != "" && process.PointerReceiver:
+		return fmt.Sprintf("craft_reflect.TypeOf((*%s%s).%s)", qualifier, process.Receiver, process.SourceName)
+	case process.Receiver != "":
+		return fmt.Sprintf("craft_reflect.TypeOf(%s%s.%s)", qualifier, process.Receiver, process.SourceName)
+	case process.Func:
+		return fmt.Sprintf("craft_reflect.TypeOf(%s%s)", qualifier, process.SourceName)
+	case process.Const:
+		// a constant is not addressable, but neither is it an interface
+		return fmt.Sprintf("craft_reflect.TypeOf(%s%s)", qualifier, process.SourceName)
+	case process.VarTypeName != "":
+		// the static type of the variable, even if it is an interface
+		return fmt.Sprintf("craft_reflect.TypeOf(&%s%s).Elem()", qualifier, process.SourceName)
+	default:
+		// a pointer to the type, so interface types are reflected too
+		return fmt.Sprintf("craft_reflect.TypeOf((*%s%s)(nil)).Elem()", qualifier, process.SourceName)
+	}
+}
+
+// InPackage reports whether the program running macros on the named
+// declaration must be compiled inside the current package. Unexported
+// declarations are not reachable from outside of it and a main package can
+// not be imported at all.
+func (c *Craft) InPackage(name string) bool {
+	return !token.IsExported(name) || c.CurrentASTFile.Name.Name == "main"
+}
+
+// invocationInPackage reports whether the host must run inside the current
+// package for the invocation: to reach its declaration, or the values of the
+// constants of a Go macro taking a macro.TypeData if any is unexported.
+func invocationInPackage(invocation Invocation) bool {
+	return invocation.Process.InPackage || (invocation.Exec == "" && slices.ContainsFunc(
+		invocation.Request.Declaration.Consts,
+		func(constant protocol.Const) bool {
+			return !token.IsExported(constant.Name)
+		},
+	))
+}
+
+// lookupConsts sets the constants of the named type on the process. It
+// reports false if the constants could not be looked up.
+func (c *Craft) lookupConsts(process *Process, typeName string) bool {
+	consts, err := c.LookupConsts(typeName)
+	if err != nil {
+		c.addError(
+			craft_error.Error{
+				Msg:            fmt.Sprintf("failed to look up the constants of %s: %s", typeName, err),
+				RelativePath:   c.Context.RelativePath,
+				GoFile:         c.Context.GoFile,
+				MacroPosition:  craft_error.PositionFromToken(process.SourcePosition),
+				SourcePosition: craft_error.PositionFromToken(process.SourcePosition),
+			},
+		)
+
+		return false
+	}
+
+	process.Consts = consts
+
+	return true
+}
+
+// lookupTypeSpec returns the declaration of the named type in the current
+// file, or a nil spec if the file does not declare it.
+func (c *Craft) lookupTypeSpec(name string) (*ast.GenDecl, *ast.TypeSpec) {
+	for _, decl := range c.CurrentASTFile.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+			if typeSpec := spec.(*ast.TypeSpec); typeSpec.Name.Name == name {
+				return genDecl, typeSpec
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func typeSpecDoc(genDecl *ast.GenDecl, spec *ast.TypeSpec) *ast.CommentGroup {
+	if genDecl.Lparen.IsValid() {
+		return spec.Doc
+	}
+
+	return genDecl.Doc
 }
 
 func (c *Craft) addProcess(process Process) {
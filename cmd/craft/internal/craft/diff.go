@@ -0,0 +1,196 @@
+package craft
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around the changes of a hunk.
+const diffContext = 3
+
+// edit is a line of a diff: an unchanged line, or a line deleted from the
+// old text or inserted in the new one.
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff returns the unified diff turning oldText, named oldName, into
+// newText, named newName, or an empty string if they are equal.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+
+	edits := diffLines(splitLines(oldText), splitLines(newText))
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+
+	// the old and new line numbers of the edits
+	oldLine := make([]int, len(edits)+1)
+	newLine := make([]int, len(edits)+1)
+
+	for i, e := range edits {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+
+		if e.op != '+' {
+			oldLine[i+1]++
+		}
+		if e.op != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	for start := 0; start < len(edits); {
+		// the next change starts a hunk, which ends once more than twice the
+		// context of unchanged lines follows a change, so the contexts of
+		// adjacent hunks neither overlap nor touch
+		first := slices.IndexFunc(edits[start:], func(e edit) bool { return e.op != ' ' })
+		if first < 0 {
+			break
+		}
+
+		first += start
+		last := first
+
+		for i := first + 1; i < len(edits) && i-last-1 <= 2*diffContext; i++ {
+			if edits[i].op != ' ' {
+				last = i
+			}
+		}
+
+		from := max(first-diffContext, 0)
+		to := min(last+diffContext+1, len(edits))
+
+		oldStart, oldCount := oldLine[from]+1, oldLine[to]-oldLine[from]
+		newStart, newCount := newLine[from]+1, newLine[to]-newLine[from]
+
+		// an empty range starts at the line before it
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+
+		for _, e := range edits[from:to] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.line)
+
+			if !strings.HasSuffix(e.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = to
+	}
+
+	return sb.String()
+}
+
+// splitLines splits the text after every newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// diffLines returns the shortest edit script turning the lines a into the
+// lines b, using the greedy algorithm of Myers.
+func diffLines(a, b []string) []edit {
+	var (
+		n, m   = len(a), len(b)
+		offset = n + m + 1
+		// v holds the furthest x reached on every diagonal k = x - y
+		v = make([]int, 2*offset+1)
+		// trace holds the diagonals -d..d of v after every step d, which
+		// are the only ones reached in d steps, so it grows with the square
+		// of the number of edits rather than with the length of the texts
+		trace [][]int
+	)
+
+search:
+	for d := 0; d <= n+m; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+
+		trace = append(trace, slices.Clone(v[offset-d:offset+d+1]))
+	}
+
+	// the script is recovered backwards from the end of both texts
+	var (
+		edits = make([]edit, 0, max(n, m))
+		x, y  = n, m
+	)
+
+	// the script ends at step len(trace), whose diagonals were not kept
+	for d := len(trace); d > 0; d-- {
+		var (
+			// prev holds the diagonals -(d-1)..d-1 reached in d-1 steps
+			prev  = trace[d-1]
+			k     = x - y
+			prevK int
+		)
+
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{op: ' ', line: a[x-1]})
+			x--
+			y--
+		}
+
+		if x == prevX {
+			edits = append(edits, edit{op: '+', line: b[y-1]})
+		} else {
+			edits = append(edits, edit{op: '-', line: a[x-1]})
+		}
+
+		x, y = prevX, prevY
+	}
+
+	// the lines left are the common prefix of the texts
+	for ; x > 0; x-- {
+		edits = append(edits, edit{op: ' ', line: a[x-1]})
+	}
+
+	slices.Reverse(edits)
+
+	return edits
+}
@@ -0,0 +1,170 @@
+package craft
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		diff     string
+	}{
+		{
+			name: "equal",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			diff: "",
+		},
+		{
+			name: "empty old file",
+			old:  "",
+			new:  "a\nb\n",
+			diff: "--- a/f\n+++ b/f\n" +
+				"@@ -0,0 +1,2 @@\n" +
+				"+a\n" +
+				"+b\n",
+		},
+		{
+			name: "no trailing newline",
+			old:  "a\nb",
+			new:  "a\nc",
+			diff: "--- a/f\n+++ b/f\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" a\n" +
+				"-b\n" +
+				"\\ No newline at end of file\n" +
+				"+c\n" +
+				"\\ No newline at end of file\n",
+		},
+		{
+			name: "trailing newline removed",
+			old:  "a\nb\n",
+			new:  "a\nb",
+			diff: "--- a/f\n+++ b/f\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" a\n" +
+				"-b\n" +
+				"+b\n" +
+				"\\ No newline at end of file\n",
+		},
+		{
+			name: "insert only",
+			old:  "a\nc\n",
+			new:  "a\nb\nc\n",
+			diff: "--- a/f\n+++ b/f\n" +
+				"@@ -1,2 +1,3 @@\n" +
+				" a\n" +
+				"+b\n" +
+				" c\n",
+		},
+		{
+			name: "delete only",
+			old:  "a\nb\nc\n",
+			new:  "a\nc\n",
+			diff: "--- a/f\n+++ b/f\n" +
+				"@@ -1,3 +1,2 @@\n" +
+				" a\n" +
+				"-b\n" +
+				" c\n",
+		},
+		{
+			// six unchanged lines join the contexts of the changes
+			name: "adjacent hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "1\nx\n3\n4\n5\n6\n7\n8\ny\n10\n",
+			diff: "--- a/f\n+++ b/f\n" +
+				"@@ -1,10 +1,10 @@\n" +
+				" 1\n-2\n+x\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+y\n 10\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			new:  "1\nx\n3\n4\n5\n6\n7\n8\n9\ny\n11\n",
+			diff: "--- a/f\n+++ b/f\n" +
+				"@@ -1,5 +1,5 @@\n" +
+				" 1\n-2\n+x\n 3\n 4\n 5\n" +
+				"@@ -7,5 +7,5 @@\n" +
+				" 7\n 8\n 9\n-10\n+y\n 11\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := unifiedDiff("a/f", "b/f", test.old, test.new); diff != test.diff {
+				t.Errorf("unifiedDiff() =\n%s\nwant:\n%s", diff, test.diff)
+			}
+		})
+	}
+}
+
+// TestDiffLines checks that the edit script of random texts turns one into
+// the other and is no longer than the one of a longest common subsequence.
+func TestDiffLines(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(30))
+
+		for i := range lines {
+			lines[i] = string(rune('a'+random.Intn(4))) + "\n"
+		}
+
+		return lines
+	}
+
+	for i := 0; i < 200; i++ {
+		a, b := randomLines(), randomLines()
+
+		var (
+			edits      = diffLines(a, b)
+			olds, news []string
+			changes    int
+		)
+
+		for _, e := range edits {
+			if e.op != '+' {
+				olds = append(olds, e.line)
+			}
+			if e.op != '-' {
+				news = append(news, e.line)
+			}
+			if e.op != ' ' {
+				changes++
+			}
+		}
+
+		if strings.Join(olds, "") != strings.Join(a, "") || strings.Join(news, "") != strings.Join(b, "") {
+			t.Fatalf("diffLines(%q, %q) = %q, which does not turn one into the other", a, b, edits)
+		}
+
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("diffLines(%q, %q) has %d changes, want %d", a, b, changes, want)
+		}
+	}
+}
+
+// lcs returns the length of a longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	lengths := make([]int, len(b)+1)
+
+	for i := range a {
+		prev := 0
+
+		for j := range b {
+			current := lengths[j+1]
+
+			if a[i] == b[j] {
+				lengths[j+1] = prev + 1
+			} else {
+				lengths[j+1] = max(lengths[j+1], lengths[j])
+			}
+
+			prev = current
+		}
+	}
+
+	return lengths[len(b)]
+}
@@ -0,0 +1,136 @@
+package craft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aria3ppp/craft/protocol"
+	"github.com/aria3ppp/craft/typedesc"
+)
+
+// hostRequests returns the requests to send to the macro host and the
+// invocation each one is for. Go macros are run by the host, which describes
+// the declarations of external macros once per declaration.
+func hostRequests(invocations []Invocation) ([]protocol.Request, []Invocation) {
+	var (
+		requests            []protocol.Request
+		requestsInvocations []Invocation
+		described           = make(map[string]bool)
+	)
+
+	for _, invocation := range invocations {
+		request := invocation.Request
+
+		if invocation.Fresh {
+			continue
+		}
+
+		if invocation.Exec != "" {
+			if described[request.Declaration.Name] {
+				continue
+			}
+
+			described[request.Declaration.Name] = true
+
+			request = protocol.Request{
+				Version:     protocol.Version,
+				Declaration: request.Declaration,
+				Describe:    true,
+			}
+		}
+
+		requests = append(requests, request)
+		requestsInvocations = append(requestsInvocations, invocation)
+	}
+
+	return requests, requestsInvocations
+}
+
+// RunExecMacros runs the invocations of external macros with the
+// descriptors of their declarations. Every executable is started once and
+// sent the requests of all of its invocations; the executables run
+// concurrently and their responses are handled in order. It returns the paths
+// of the written files and reports false if a macro failed.
+func (c *Craft) RunExecMacros(
+	ctx context.Context,
+	invocations []Invocation,
+	descriptors map[string]*typedesc.Descriptor,
+) (written []string, ok bool) {
+	var (
+		execs            []string
+		execsInvocations = make(map[string][]Invocation)
+	)
+
+	for _, invocation := range invocations {
+		if invocation.Exec == "" {
+			continue
+		}
+
+		descriptor, described := descriptors[invocation.Request.Declaration.Name]
+		if !described {
+			// the host failed to describe it and reported why
+			continue
+		}
+
+		invocation.Request.Descriptor = descriptor
+
+		if _, exists := execsInvocations[invocation.Exec]; !exists {
+			execs = append(execs, invocation.Exec)
+		}
+
+		execsInvocations[invocation.Exec] = append(execsInvocations[invocation.Exec], invocation)
+	}
+
+	var (
+		execsResponses = make([][]protocol.Response, len(execs))
+		execsErrs      = make([]error, len(execs))
+		pool           = NewPool(c.Context.Jobs)
+	)
+
+	for i, execPath := range execs {
+		pool.Go(func() {
+			execInvocations := execsInvocations[execPath]
+			requests := make([]protocol.Request, 0, len(execInvocations))
+
+			for _, invocation := range execInvocations {
+				requests = append(requests, invocation.Request)
+			}
+
+			execsResponses[i], execsErrs[i] = c.RunHost(ctx, execPath, nil, requests)
+		})
+	}
+
+	pool.Wait()
+
+	ok = true
+
+	for i, execPath := range execs {
+		var (
+			execInvocations = execsInvocations[execPath]
+			responses       = execsResponses[i]
+		)
+
+		if err := execsErrs[i]; err != nil {
+			var responseErr *ResponseError
+			if errors.As(err, &responseErr) {
+				err = fmt.Errorf("external macro %q returned an invalid response: %w", execInvocations[0].Macro.AST.Package, err)
+			} else {
+				err = fmt.Errorf("external macro package %q: %w", execInvocations[0].Macro.AST.Package, err)
+			}
+
+			c.printHostError(err, execInvocations)
+
+			ok = false
+		}
+
+		for i, response := range responses {
+			responseWritten, responseOK := c.HandleResponse(execInvocations[i], response)
+
+			written = append(written, responseWritten...)
+			ok = ok && responseOK
+		}
+	}
+
+	return written, ok
+}
@@ -0,0 +1,114 @@
+package craft
+
+import (
+	"go/ast"
+	"path/filepath"
+
+	"github.com/aria3ppp/craft/macro"
+	"github.com/aria3ppp/craft/typedesc"
+)
+
+// ParseFieldMacros parses the macros on the fields of a struct type from
+// their doc and line comments. It returns nil if spec is not a struct type.
+// The fields of a type are parsed once, however many declarations of the
+// type and variables of it have macros, so their errors are reported once.
+func (c *Craft) ParseFieldMacros(spec *ast.TypeSpec) []Field {
+	c.fieldsMu.Lock()
+	defer c.fieldsMu.Unlock()
+
+	if fields, exists := c.fields[spec]; exists {
+		return fields
+	}
+
+	fields := c.parseFieldMacros(spec)
+
+	if c.fields == nil {
+		c.fields = make(map[*ast.TypeSpec][]Field)
+	}
+	c.fields[spec] = fields
+
+	return fields
+}
+
+func (c *Craft) parseFieldMacros(spec *ast.TypeSpec) (fields []Field) {
+	structType, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return nil
+	}
+
+	// errors are reported at the type, whichever declaration the fields
+	// are passed to
+	sourcePosition := c.FileSet.Position(spec.Pos())
+
+	index := 0
+
+	for _, field := range structType.Fields.List {
+		var comments []*ast.Comment
+
+		if field.Doc != nil {
+			comments = append(comments, field.Doc.List...)
+		}
+
+		if field.Comment != nil {
+			comments = append(comments, field.Comment.List...)
+		}
+
+		names := make([]string, 0, len(field.Names))
+
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+
+		if len(names) == 0 {
+			names = append(names, typedesc.EmbeddedName(field.Type))
+		}
+
+		if len(comments) > 0 {
+			if macros := c.ParseMacros(comments, sourcePosition, true); len(macros) > 0 {
+				for i, name := range names {
+					fields = append(fields, Field{
+						Name:   name,
+						Index:  index + i,
+						Macros: macros,
+					})
+				}
+			}
+		}
+
+		index += len(names)
+	}
+
+	return fields
+}
+
+// macroFields returns the fields as passed to macros. Positions are relative
+// to the module root.
+func (c *Craft) macroFields(fields []Field) []macro.Field {
+	var (
+		filePath    = filepath.ToSlash(filepath.Join(c.Context.RelativePath, c.Context.GoFile))
+		macroFields = make([]macro.Field, 0, len(fields))
+	)
+
+	for _, field := range fields {
+		macroField := macro.Field{
+			Name:  field.Name,
+			Index: field.Index,
+		}
+
+		for _, fieldMacro := range field.Macros {
+			position := fieldMacro.MacroPosition
+			position.Filename = filePath
+
+			macroField.Macros = append(macroField.Macros, macro.FieldMacro{
+				Package:  fieldMacro.AST.Package,
+				Macro:    fieldMacro.AST.Macro,
+				Input:    fieldMacro.Input,
+				Position: position,
+			})
+		}
+
+		macroFields = append(macroFields, macroField)
+	}
+
+	return macroFields
+}
@@ -0,0 +1,238 @@
+package craft
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/imports"
+)
+
+// snippetContext is the number of lines shown around the line of a syntax
+// error in a generated file.
+const snippetContext = 2
+
+// majorVersion matches the major version element of an import path.
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// formatOutput checks that the generated Go file at path, named name in
+// errors, parses, then fixes its imports and formats it as goimports does.
+// The error of a file that does not parse shows where in the file it is.
+func formatOutput(path string, name string, content string) (string, error) {
+	fileSet := token.NewFileSet()
+
+	file, err := parser.ParseFile(fileSet, name, content, parser.ParseComments)
+	if err != nil {
+		return "", syntaxError(content, err)
+	}
+
+	fixImports(fileSet, file, path)
+
+	var bytesBuffer bytes.Buffer
+
+	if err := format.Node(&bytesBuffer, fileSet, file); err != nil {
+		return "", fmt.Errorf("%s: failed to format: %s", name, err)
+	}
+
+	// imports are only sorted and grouped: fixing them would search GOPATH
+	// and the module cache for every package the file misses
+	formatted, err := imports.Process(path, bytesBuffer.Bytes(), &imports.Options{
+		Comments:   true,
+		TabIndent:  true,
+		TabWidth:   8,
+		FormatOnly: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to format: %s", name, err)
+	}
+
+	return string(formatted), nil
+}
+
+// fixImports removes the unused imports of the generated file at path and
+// adds the missing ones the other files of its package import.
+func fixImports(fileSet *token.FileSet, file *ast.File, path string) {
+	// the unresolved names the selectors of the file are qualified with,
+	// which are package names
+	qualifiers := make(map[string]bool)
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		if selector, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := selector.X.(*ast.Ident); ok && ident.Obj == nil {
+				qualifiers[ident.Name] = true
+			}
+		}
+
+		return true
+	})
+
+	var (
+		imported = make(map[string]bool)
+		unused   []*ast.ImportSpec
+	)
+
+	for _, spec := range file.Imports {
+		name := importSpecName(spec)
+
+		switch {
+		// the name of the package is not known, or it is imported for its
+		// side effects or into the file scope
+		case name == "" || name == "_" || name == ".":
+		case qualifiers[name]:
+			imported[name] = true
+		default:
+			unused = append(unused, spec)
+		}
+	}
+
+	for _, spec := range unused {
+		var (
+			name          string
+			importPath, _ = strconv.Unquote(spec.Path.Value)
+		)
+
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+
+		astutil.DeleteNamedImport(fileSet, file, name, importPath)
+	}
+
+	var (
+		specs   = packageImports(path)
+		missing []string
+	)
+
+	for name := range specs {
+		if qualifiers[name] && !imported[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	slices.Sort(missing)
+
+	for _, name := range missing {
+		spec := specs[name]
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+
+		if spec.Name != nil {
+			astutil.AddNamedImport(fileSet, file, spec.Name.Name, importPath)
+		} else {
+			astutil.AddImport(fileSet, file, importPath)
+		}
+	}
+}
+
+// packageImports returns the imports of the other Go files in the directory
+// of path by the name they are referred to with.
+func packageImports(path string) map[string]*ast.ImportSpec {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+
+	var (
+		fileSet = token.NewFileSet()
+		specs   = make(map[string]*ast.ImportSpec)
+	)
+
+	for _, entry := range entries {
+		filePath := filepath.Join(filepath.Dir(path), entry.Name())
+
+		if entry.IsDir() || filepath.Ext(filePath) != ".go" || filePath == path {
+			continue
+		}
+
+		file, err := parser.ParseFile(fileSet, filePath, nil, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+
+		for _, spec := range file.Imports {
+			if name := importSpecName(spec); name != "" && name != "_" && name != "." {
+				specs[name] = spec
+			}
+		}
+	}
+
+	return specs
+}
+
+// importSpecName returns the name an import is referred to with. The name of a
+// package imported without one is assumed from its path as goimports does,
+// or empty if it can not be.
+func importSpecName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+
+	importPath, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return ""
+	}
+
+	elems := strings.Split(importPath, "/")
+	name := elems[len(elems)-1]
+
+	if len(elems) > 1 && majorVersion.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+
+	name = strings.TrimPrefix(name, "go-")
+
+	if i := strings.IndexAny(name, ".-"); i >= 0 {
+		name = name[:i]
+	}
+
+	if !token.IsIdentifier(name) {
+		return ""
+	}
+
+	return name
+}
+
+// syntaxError returns the error of the parser along with the lines of the
+// content around the first error.
+func syntaxError(content string, err error) error {
+	var errs scanner.ErrorList
+	if !errors.As(err, &errs) || len(errs) == 0 {
+		return err
+	}
+
+	var (
+		first = errs[0]
+		lines = strings.Split(content, "\n")
+		from  = max(first.Pos.Line-snippetContext, 1)
+		to    = min(first.Pos.Line+snippetContext, len(lines))
+		sb    strings.Builder
+	)
+
+	fmt.Fprintf(&sb, "%s\n", first)
+
+	for line := from; line <= to; line++ {
+		marker := " "
+		if line == first.Pos.Line {
+			marker = ">"
+		}
+
+		fmt.Fprintf(&sb, "\t%s %4d | %s\n", marker, line, lines[line-1])
+	}
+
+	if len(errs) > 1 {
+		fmt.Fprintf(&sb, "\t(and %d more errors)\n", len(errs)-1)
+	}
+
+	return errors.New(strings.TrimSuffix(sb.String(), "\n"))
+}
@@ -0,0 +1,54 @@
+package craft
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatOutput(t *testing.T) {
+	dir := t.TempDir()
+
+	sibling := "package a\n\nimport (\n\tenc \"encoding/json\"\n\t\"strings\"\n)\n"
+
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(sibling), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "package a\n\nimport (\n\"os\"\n\"fmt\"\n)\n\n" +
+		"func F() bool { return strings.ToUpper(\"a\") == fmt.Sprint(enc.Valid(nil), bytes.MinRead) }\n"
+
+	want := "package a\n\nimport (\n\tenc \"encoding/json\"\n\t\"fmt\"\n\t\"strings\"\n)\n\n" +
+		"func F() bool { return strings.ToUpper(\"a\") == fmt.Sprint(enc.Valid(nil), bytes.MinRead) }\n"
+
+	// os is unused, strings and enc are imported by the package and bytes
+	// is not, so it is left for the compiler to report
+	got, err := formatOutput(filepath.Join(dir, "F_m_Gen.crafted.go"), "F_m_Gen.crafted.go", content)
+	if err != nil {
+		t.Fatalf("formatOutput: %s", err)
+	}
+
+	if got != want {
+		t.Errorf("formatOutput() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatOutputSyntaxError(t *testing.T) {
+	content := "package a\n\nfunc F() {}\n\nfunc broken( {\n}\n\nvar _ = 1\n"
+
+	_, err := formatOutput(filepath.Join(t.TempDir(), "a.go"), "a.go", content)
+	if err == nil {
+		t.Fatal("formatOutput: no error")
+	}
+
+	msg := err.Error()
+
+	if !strings.HasPrefix(msg, "a.go:5:") {
+		t.Errorf("error %q is not at line 5", msg)
+	}
+
+	if !strings.Contains(msg, ">    5 | func broken( {") {
+		t.Errorf("error %q does not show the line", msg)
+	}
+}
@@ -0,0 +1,127 @@
+package craft
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// generatedHeader is the line marking a file as generated, see
+	// https://go.dev/s/generatedcode.
+	generatedHeader = "// Code generated by craft. DO NOT EDIT."
+	// the provenance lines of the header
+	sourceHeaderPrefix     = "// craft:source "
+	invocationHeaderPrefix = "// craft:invocation "
+	macroHeaderPrefix      = "// craft:macro "
+	versionHeaderPrefix    = "// craft:version "
+	hashHeaderPrefix       = "// craft:hash "
+)
+
+// header is the header craft writes at the top of the Go files macros
+// generate: the generated code line followed by the provenance of the file.
+//
+// Source and Line locate the macro invocation, so the file is removed once
+// the invocation is gone. Macro is the import path of the macro package, or
+// the path of the executable of an external macro, and MacroVersion its
+// version. Hash is the hash of the inputs of a Go macro, empty for an
+// external macro.
+type header struct {
+	Source       string
+	Line         int
+	Invocation   string
+	Macro        string
+	MacroVersion string
+	CraftVersion string
+	Hash         string
+}
+
+// invocationHeader returns the header of the output of the invocation.
+func (c *Craft) invocationHeader(invocation Invocation) header {
+	return header{
+		Source:       c.Context.GoFile,
+		Line:         invocation.Macro.MacroPosition.Line,
+		Invocation:   invocationText(invocation.Macro),
+		Macro:        invocation.Request.Package,
+		MacroVersion: invocation.MacroVersion,
+		CraftVersion: Version(),
+		Hash:         invocation.Hash,
+	}
+}
+
+// invocationText returns the macro invocation as written in the source, on
+// a single line.
+func invocationText(macro *Macro) string {
+	return strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(macro.Text)
+}
+
+// String returns the header as the comment lines heading the file.
+func (h header) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(generatedHeader + "\n")
+	fmt.Fprintf(&sb, "%s%s:%d\n", sourceHeaderPrefix, h.Source, h.Line)
+	sb.WriteString(invocationHeaderPrefix + h.Invocation + "\n")
+	sb.WriteString(strings.TrimSpace(macroHeaderPrefix+h.Macro+" "+h.MacroVersion) + "\n")
+	sb.WriteString(versionHeaderPrefix + h.CraftVersion + "\n")
+
+	if h.Hash != "" {
+		sb.WriteString(hashHeaderPrefix + h.Hash + "\n")
+	}
+
+	return sb.String()
+}
+
+// readHeader returns the header of the generated file, or false if the file
+// does not exist or was not generated by craft.
+func readHeader(path string) (header, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return header{}, false
+	}
+	defer file.Close()
+
+	var (
+		h       header
+		scanner = bufio.NewScanner(file)
+	)
+
+	// the header is among the comments heading the file
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line != "" && !strings.HasPrefix(line, "//") {
+			break
+		}
+
+		if value, ok := strings.CutPrefix(line, sourceHeaderPrefix); ok {
+			h.Source = strings.TrimSpace(value)
+
+			// files of older versions of craft have no line
+			if source, line, hasLine := strings.Cut(h.Source, ":"); hasLine {
+				h.Source = source
+				h.Line, _ = strconv.Atoi(line)
+			}
+		}
+
+		if value, ok := strings.CutPrefix(line, invocationHeaderPrefix); ok {
+			h.Invocation = value
+		}
+
+		if value, ok := strings.CutPrefix(line, macroHeaderPrefix); ok {
+			h.Macro, h.MacroVersion, _ = strings.Cut(strings.TrimSpace(value), " ")
+		}
+
+		if value, ok := strings.CutPrefix(line, versionHeaderPrefix); ok {
+			h.CraftVersion = strings.TrimSpace(value)
+		}
+
+		if value, ok := strings.CutPrefix(line, hashHeaderPrefix); ok {
+			h.Hash = strings.TrimSpace(value)
+		}
+	}
+
+	return h, h.Source != ""
+}
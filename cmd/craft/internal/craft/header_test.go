@@ -0,0 +1,160 @@
+package craft
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	craft_parser "github.com/aria3ppp/craft/parser"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		header header
+	}{
+		{
+			name: "go macro",
+			header: header{
+				Source:       "user.go",
+				Line:         12,
+				Invocation:   "json.Marshal(`omitempty`)",
+				Macro:        "example.com/macros/json",
+				MacroVersion: "v1.2.0",
+				CraftVersion: "v0.3.0",
+				Hash:         "4f6f493bc3d0423f",
+			},
+		},
+		{
+			name: "external macro",
+			header: header{
+				Source:       "user.go",
+				Line:         3,
+				Invocation:   "gen.Stringer(``)",
+				Macro:        "exec:./tools/gen",
+				MacroVersion: "sha256:02c9e1a9",
+				CraftVersion: "v0.3.0",
+			},
+		},
+		{
+			name: "unknown versions",
+			header: header{
+				Source:     "user.go",
+				Line:       1,
+				Invocation: "m.Gen(``)",
+				Macro:      "example.com/m",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "User_m_Gen.crafted.go")
+
+			content := test.header.String() + "\npackage user\n\n// Doc is not part of the header.\nfunc Doc() {}\n"
+
+			if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := readHeader(path)
+			if !ok {
+				t.Fatalf("readHeader(%q) found no header", content)
+			}
+
+			if got != test.header {
+				t.Errorf("readHeader() = %+v, want %+v", got, test.header)
+			}
+		})
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		header  header
+		ok      bool
+	}{
+		{
+			// files of older versions of craft have no line
+			name:    "without line",
+			content: generatedHeader + "\n" + sourceHeaderPrefix + "user.go\n\npackage user\n",
+			header:  header{Source: "user.go"},
+			ok:      true,
+		},
+		{
+			name:    "not generated by craft",
+			content: "// Code generated by stringer. DO NOT EDIT.\n\npackage user\n",
+		},
+		{
+			name:    "after the package clause",
+			content: "package user\n\n" + sourceHeaderPrefix + "user.go:3\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "User_m_Gen.crafted.go")
+
+			if err := os.WriteFile(path, []byte(test.content), 0o666); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := readHeader(path)
+			if ok != test.ok || got != test.header {
+				t.Errorf("readHeader() = %+v, %t, want %+v, %t", got, ok, test.header, test.ok)
+			}
+		})
+	}
+
+	if _, ok := readHeader(filepath.Join(t.TempDir(), "missing.go")); ok {
+		t.Error("readHeader found the header of a missing file")
+	}
+}
+
+func TestInvocationText(t *testing.T) {
+	src := "package p\n\n" +
+		"// T is documented.\n" +
+		"// #m.Raw(`raw`)\n" +
+		"// #m.Args(name: \"users\", n: 3) \n" +
+		"/* #m.Block() */\n" +
+		"type T int\n"
+
+	fileSet := token.NewFileSet()
+
+	file, err := parser.ParseFile(fileSet, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	macroASTParser, err := craft_parser.NewMacroASTParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		c      = &Craft{Context: &Context{GoFile: "p.go"}, FileSet: fileSet, Parser: macroASTParser}
+		doc    = file.Decls[0].(*ast.GenDecl).Doc
+		macros = c.ParseMacros(doc.List, fileSet.Position(file.Decls[0].Pos()), true)
+		texts  []string
+	)
+
+	for _, macro := range macros {
+		texts = append(texts, invocationText(macro))
+	}
+
+	want := []string{"m.Raw(`raw`)", `m.Args(name: "users", n: 3)`, "m.Block()"}
+
+	if !slices.Equal(texts, want) {
+		t.Errorf("invocation texts = %q, want %q (errors %v)", texts, want, c.Errs)
+	}
+
+	if got, want := invocationText(&Macro{Text: "m.Gen(`a\rb`)"}), "m.Gen(`a\\rb`)"; got != want {
+		t.Errorf("invocationText() = %q, want %q", got, want)
+	}
+}
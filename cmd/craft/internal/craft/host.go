@@ -0,0 +1,222 @@
+package craft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aria3ppp/craft/protocol"
+)
+
+// RunHost starts the macro host name with args in the current directory,
+// sends it the requests and returns its responses in the order of the
+// requests. The requests are numbered in order. External macros are hosts of
+// their own.
+//
+// A host that does not answer a request within the macro timeout is killed
+// and the request answered with a diagnostic saying so. A new host is
+// started for the requests after it, so one slow macro does not keep the
+// others from running. The host is killed when ctx is done, and RunHost
+// returns a *CanceledError for the request it was running, along with the
+// responses to the requests answered before it. A host that can not be
+// started, or that does not answer the requests as the protocol says, makes
+// RunHost return a *ResponseError.
+func (c *Craft) RunHost(ctx context.Context, name string, args []string, requests []protocol.Request) ([]protocol.Response, error) {
+	requests = slices.Clone(requests)
+
+	for i := range requests {
+		requests[i].ID = i
+	}
+
+	var all []protocol.Response
+
+	for start := 0; start < len(requests); {
+		responses, err := c.runHost(ctx, name, args, requests[start:])
+
+		// the host numbers the requests it is sent from zero
+		for i := range responses {
+			responses[i].ID = start + i
+		}
+
+		all = append(all, responses...)
+
+		var canceledErr *CanceledError
+		if !errors.As(err, &canceledErr) {
+			return all, err
+		}
+
+		canceledErr.ID += start
+
+		if ctx.Err() != nil {
+			return all, canceledErr
+		}
+
+		request := requests[canceledErr.ID]
+
+		if request.Describe {
+			all = append(all, protocol.Errorf(request, "describing %s: %s", request.Declaration.Name, canceledErr.Cause))
+		} else {
+			all = append(all, protocol.Errorf(request, "macro %s on %s: %s", request.Macro, request.Declaration.Name, canceledErr.Cause))
+		}
+
+		start = canceledErr.ID + 1
+	}
+
+	return all, nil
+}
+
+// runHost runs the requests on one host, which is killed when ctx is done or
+// when it does not answer a request within the macro timeout.
+func (c *Craft) runHost(ctx context.Context, name string, args []string, requests []protocol.Request) ([]protocol.Response, error) {
+	requests = slices.Clone(requests)
+
+	for i := range requests {
+		requests[i].ID = i
+	}
+
+	hostCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// the host answers in order, so the timer only ever runs for the request
+	// being answered
+	var timer *time.Timer
+
+	if timeout := c.Context.MacroTimeout; timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			cancel(fmt.Errorf("timed out after %s", timeout))
+		})
+		defer timer.Stop()
+	}
+
+	cmd := Command(hostCtx, name, args...)
+	cmd.Dir = c.Context.PWD
+
+	var stderr bytes.Buffer
+
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("[INTERNAL ERROR] [file a bug] failed to pipe the host input: %s", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("[INTERNAL ERROR] [file a bug] failed to pipe the host output: %s", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, &ResponseError{Err: fmt.Errorf("failed to start: %w", err)}
+	}
+
+	// the requests are sent while the responses are read, so neither end
+	// blocks on a full pipe
+	sendErr := make(chan error, 1)
+
+	go func() {
+		encoder := protocol.NewEncoder(stdin)
+
+		for _, request := range requests {
+			if err := encoder.Encode(request); err != nil {
+				sendErr <- err
+				stdin.Close()
+				return
+			}
+		}
+
+		sendErr <- stdin.Close()
+	}()
+
+	var (
+		decoder   = protocol.NewDecoder(stdout)
+		responses = make([]protocol.Response, len(requests))
+		received  = make([]bool, len(requests))
+		decodeErr error
+	)
+
+	for range requests {
+		var response protocol.Response
+
+		if decodeErr = decoder.Decode(&response); decodeErr != nil {
+			break
+		}
+
+		if response.ID < 0 || response.ID >= len(requests) || received[response.ID] {
+			decodeErr = fmt.Errorf("unexpected response id %d", response.ID)
+			break
+		}
+
+		responses[response.ID] = response
+		received[response.ID] = true
+
+		if timer != nil {
+			timer.Reset(c.Context.MacroTimeout)
+		}
+	}
+
+	// the rest of the output is drained, so the host is not blocked on
+	// writing it
+	_, _ = io.Copy(io.Discard, stdout)
+
+	waitErr := cmd.Wait()
+
+	// the host answers in order, so every request before the pending one
+	// was answered
+	if pending := slices.Index(received, false); pending >= 0 && hostCtx.Err() != nil {
+		return responses[:pending], &CanceledError{ID: pending, Cause: cancelCause(hostCtx)}
+	}
+
+	if waitErr != nil {
+		var exitErr *exec.ExitError
+		if errors.As(waitErr, &exitErr) {
+			return nil, fmt.Errorf("macro host failed: %s\n%s", waitErr, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("[INTERNAL ERROR] [file a bug] failed to run the host: %s", waitErr)
+	}
+
+	if errors.Is(decodeErr, io.EOF) {
+		decodeErr = fmt.Errorf("exited before answering request %d", slices.Index(received, false))
+	}
+
+	if decodeErr != nil {
+		return nil, &ResponseError{Err: decodeErr, Stderr: strings.TrimSpace(stderr.String())}
+	}
+
+	if err := <-sendErr; err != nil {
+		return nil, &ResponseError{Err: fmt.Errorf("failed to send the requests: %w", err), Stderr: strings.TrimSpace(stderr.String())}
+	}
+
+	for _, response := range responses {
+		if response.Version != protocol.Version {
+			return nil, &ResponseError{Err: fmt.Errorf("speaks protocol version %d, want %d", response.Version, protocol.Version)}
+		}
+	}
+
+	return responses, nil
+}
+
+// ResponseError is returned when a macro host fails to start or does not
+// answer the requests as the protocol says. Stderr is what the host wrote to
+// its standard error.
+type ResponseError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Stderr == "" {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%s\n%s", e.Err, e.Stderr)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,128 @@
+package craft
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aria3ppp/craft/protocol"
+)
+
+// TestHelperHost is not a test but the macro host run by the tests of
+// RunHost. It answers requests with a file named after the macro, and never
+// answers requests for the Slow macro.
+func TestHelperHost(t *testing.T) {
+	if os.Getenv("CRAFT_TEST_HOST") != "1" {
+		t.Skip("run as a macro host by the tests of RunHost")
+	}
+
+	var (
+		decoder = protocol.NewDecoder(os.Stdin)
+		encoder = protocol.NewEncoder(os.Stdout)
+	)
+
+	for {
+		var request protocol.Request
+
+		if err := decoder.Decode(&request); err != nil {
+			if errors.Is(err, io.EOF) {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+
+		if request.Macro == "Slow" {
+			time.Sleep(time.Minute)
+		}
+
+		_ = encoder.Encode(protocol.Response{
+			Version: protocol.Version,
+			ID:      request.ID,
+			Files:   []protocol.File{{Path: request.Macro}},
+		})
+	}
+}
+
+func runHelperHost(ctx context.Context, t *testing.T, macros ...string) ([]protocol.Response, error) {
+	t.Helper()
+	t.Setenv("CRAFT_TEST_HOST", "1")
+
+	name, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := make([]protocol.Request, 0, len(macros))
+
+	for _, macro := range macros {
+		requests = append(requests, protocol.Request{
+			Version:     protocol.Version,
+			Macro:       macro,
+			Declaration: protocol.Declaration{Name: "T"},
+		})
+	}
+
+	c := &Craft{Context: &Context{PWD: t.TempDir(), MacroTimeout: 500 * time.Millisecond}}
+
+	return c.RunHost(ctx, name, []string{"-test.run", "^TestHelperHost$"}, requests)
+}
+
+func TestRunHostTimeout(t *testing.T) {
+	responses, err := runHelperHost(context.Background(), t, "A", "Slow", "B", "Slow", "C")
+	if err != nil {
+		t.Fatalf("RunHost: %s", err)
+	}
+
+	var got []string
+
+	for i, response := range responses {
+		if response.ID != i {
+			t.Errorf("response %d has ID %d", i, response.ID)
+		}
+
+		switch {
+		case len(response.Files) == 1:
+			got = append(got, response.Files[0].Path)
+		case len(response.Diagnostics) == 1:
+			got = append(got, response.Diagnostics[0].Message)
+		default:
+			t.Errorf("response %d = %+v", i, response)
+		}
+	}
+
+	// a new host runs the requests after a macro timed out
+	want := []string{
+		"A",
+		"macro Slow on T: timed out after 500ms",
+		"B",
+		"macro Slow on T: timed out after 500ms",
+		"C",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("responses = %q, want %q", got, want)
+	}
+}
+
+func TestRunHostCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	responses, err := runHelperHost(ctx, t, "A", "Slow", "B")
+
+	var canceledErr *CanceledError
+	if !errors.As(err, &canceledErr) {
+		t.Fatalf("RunHost: %v, want a *CanceledError", err)
+	}
+
+	// the requests after the one interrupted are not run
+	if canceledErr.ID != 1 || len(responses) != 1 {
+		t.Errorf("RunHost() = %d responses, canceled request %d, want 1 and 1", len(responses), canceledErr.ID)
+	}
+}
@@ -0,0 +1,164 @@
+package craft
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/aria3ppp/craft/macro"
+	"github.com/aria3ppp/craft/protocol"
+)
+
+// inputsHash returns the hash of everything the output of a Go macro
+// invocation depends on: the declaration with its fields and constants,
+// whatever the macro gets of them, the header of the output, which holds the
+// invocation and the versions of the macro package and of craft, and the
+// sources the reflected type and the macro depend on. It returns an empty
+// string if the sources could not be hashed, so the invocation is never
+// fresh.
+func (c *Craft) inputsHash(ctx context.Context, invocation Invocation) string {
+	sourcesHash := c.sourcesHash(ctx)
+	if sourcesHash == "" {
+		return ""
+	}
+
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+
+	// encoding requests and declarations never fails
+	_ = encoder.Encode(invocation.Request)
+	_ = encoder.Encode(struct {
+		Source string
+		Fields []macro.Field
+		Consts []protocol.Const
+	}{
+		Source: invocation.Process.Source,
+		Fields: c.macroFields(invocation.Process.Fields),
+		Consts: c.protocolConsts(invocation.Process.Consts),
+	})
+
+	fmt.Fprintf(hash, "header %s\nsources %s\n", c.invocationHeader(invocation), sourcesHash)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// sourcesHash returns the hash of the code of the current package and of
+// the versions, or the sources if they have no version, of the Go macro
+// packages and every package they and the current package depend on. It is
+// computed once per run, and is empty if the sources could not be listed.
+//
+// Comments of the current package are left out, so editing the invocation
+// or the doc of one declaration does not make the macros of the others run:
+// macros only see the comments of their own declaration, which inputsHash
+// covers. Files generated by craft are part of the package the host reflects
+// on, e.g. the methods they declare, so they are hashed too, except the
+// hidden ones which are left out of the host.
+func (c *Craft) sourcesHash(ctx context.Context) string {
+	c.sourcesHashOnce.Do(func() {
+		hash := sha256.New()
+
+		if err := c.hashPackageCode(hash); err != nil {
+			c.verbosef("fresh: disabled: %s", err)
+			return
+		}
+
+		var macroPackages []string
+
+		for pkg, importPath := range c.Context.MacroPackageImports {
+			if _, isExec := c.Context.ExecMacro(pkg); !isExec {
+				macroPackages = append(macroPackages, importPath)
+			}
+		}
+
+		slices.Sort(macroPackages)
+
+		// the Go files of the current package are hashed above
+		err := c.hashDeps(ctx, hash, macroPackages, func(dir, file string) bool {
+			return dir == c.Context.PWD && filepath.Ext(file) == ".go"
+		})
+		if err != nil {
+			c.verbosef("fresh: disabled: %s", err)
+			return
+		}
+
+		c.sourcesHashValue = hex.EncodeToString(hash.Sum(nil))
+	})
+
+	return c.sourcesHashValue
+}
+
+// hashPackageCode writes the code of the Go files of the current directory
+// but the hidden ones to the hash.
+func (c *Craft) hashPackageCode(hash io.Writer) error {
+	goFiles, err := filepath.Glob(filepath.Join(c.Context.PWD, "*.go"))
+	if err != nil {
+		return err
+	}
+
+	for _, goFile := range goFiles {
+		if slices.Contains(c.hidden, goFile) {
+			continue
+		}
+
+		if err := hashCode(hash, goFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashCode writes the name and the tokens of the Go file to the hash,
+// without its comments.
+func hashCode(hash io.Writer, path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		fileSet = token.NewFileSet()
+		s       scanner.Scanner
+	)
+
+	fmt.Fprintf(hash, "file %s\n", filepath.Base(path))
+
+	s.Init(fileSet.AddFile(path, fileSet.Base(), len(src)), src, nil, 0)
+
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		fmt.Fprintf(hash, "%s %q\n", tok, lit)
+	}
+
+	return nil
+}
+
+// fresh reports whether the output of the invocation was generated from the
+// same inputs, so the macro does not have to run again. External macros
+// always run, as the files they generate are not known beforehand.
+func (c *Craft) fresh(invocation Invocation) bool {
+	if c.Context.Force || invocation.Hash == "" {
+		return false
+	}
+
+	path, err := c.outputPath(invocation.Request.Output)
+	if err != nil {
+		return false
+	}
+
+	header, ok := readHeader(path)
+
+	return ok && header.Hash == invocation.Hash
+}
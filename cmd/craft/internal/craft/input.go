@@ -0,0 +1,120 @@
+package craft
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"strconv"
+
+	craft_error "github.com/aria3ppp/craft/error"
+	"github.com/aria3ppp/craft/macro"
+	craft_parser "github.com/aria3ppp/craft/parser"
+)
+
+// MacroInput converts the parsed invocation to the input passed to the macro.
+// Invalid arguments are reported at their position in the comment and make
+// ok false.
+func (c *Craft) MacroInput(
+	macroAST *craft_parser.MacroAST,
+	commentPosition token.Position,
+	sourcePosition token.Position,
+) (input macro.Input, ok bool) {
+	ok = true
+
+	report := func(msg string, offset int) {
+		ok = false
+
+		c.addError(
+			craft_error.Error{
+				Msg:            msg,
+				RelativePath:   c.Context.RelativePath,
+				GoFile:         c.Context.GoFile,
+				MacroPosition:  craft_error.PositionFromToken(c.commentPosition(commentPosition, offset)),
+				SourcePosition: craft_error.PositionFromToken(sourcePosition),
+			},
+		)
+	}
+
+	input.Raw = macroAST.Input
+
+	named := make(map[string]bool, len(macroAST.Args))
+
+	for _, arg := range macroAST.Args {
+		switch {
+		case arg.Name == "" && len(named) > 0:
+			report("positional argument after named arguments", arg.Pos.Offset)
+		case named[arg.Name]:
+			report(fmt.Sprintf("duplicate argument %q", arg.Name), arg.Pos.Offset)
+		case arg.Name != "":
+			named[arg.Name] = true
+		}
+
+		value, err := macroValue(arg.Value, report)
+		if err != nil {
+			continue
+		}
+
+		input.Args = append(input.Args, macro.Arg{
+			Name:  arg.Name,
+			Value: value,
+		})
+	}
+
+	return input, ok
+}
+
+var errInvalidValue = errors.New("invalid value")
+
+func macroValue(
+	v *craft_parser.Value,
+	report func(msg string, offset int),
+) (value macro.Value, err error) {
+	switch {
+	case v.String != nil:
+		value = macro.Value{Kind: macro.KindString, String: *v.String}
+	case v.Int != nil:
+		i, err := strconv.ParseInt(*v.Int, 0, 64)
+		if err != nil {
+			report(fmt.Sprintf("integer %s out of range", *v.Int), v.Pos.Offset)
+			return macro.Value{}, errInvalidValue
+		}
+		value = macro.Value{Kind: macro.KindInt, Int: i}
+	case v.Float != nil:
+		f, err := strconv.ParseFloat(*v.Float, 64)
+		if err != nil {
+			report(fmt.Sprintf("float %s out of range", *v.Float), v.Pos.Offset)
+			return macro.Value{}, errInvalidValue
+		}
+		value = macro.Value{Kind: macro.KindFloat, Float: f}
+	case v.Bool != nil:
+		value = macro.Value{Kind: macro.KindBool, Bool: *v.Bool == "true"}
+	case v.Ident != nil:
+		value = macro.Value{Kind: macro.KindIdent, Ident: *v.Ident}
+	case v.List != nil:
+		value = macro.Value{Kind: macro.KindList, List: make([]macro.Value, 0, len(v.List.Values))}
+
+		for _, elem := range v.List.Values {
+			elemValue, elemErr := macroValue(elem, report)
+			if elemErr != nil {
+				err = elemErr
+				continue
+			}
+
+			if len(value.List) > 0 && elemValue.Kind != value.List[0].Kind {
+				report(fmt.Sprintf("list element of type %s in a list of %s", elemValue.Kind, value.List[0].Kind), elem.Pos.Offset)
+				err = errInvalidValue
+				continue
+			}
+
+			value.List = append(value.List, elemValue)
+		}
+	}
+
+	return value, err
+}
+
+// commentPosition returns the position of the offset into the text of the
+// comment starting at commentPosition.
+func (c *Craft) commentPosition(commentPosition token.Position, offset int) token.Position {
+	return c.FileSet.Position(token.Pos(commentPosition.Offset) + 1 + token.Pos(offset))
+}
@@ -0,0 +1,126 @@
+package craft
+
+import (
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aria3ppp/craft/macro"
+	craft_parser "github.com/aria3ppp/craft/parser"
+)
+
+func TestMacroInput(t *testing.T) {
+	tests := []struct {
+		text  string
+		input macro.Input
+		// errs are the errors reported, at the start of at in the comment
+		errs []inputError
+	}{
+		{
+			text:  "#pkg.M(`raw`)",
+			input: macro.Input{Raw: "raw"},
+		},
+		{
+			text: `#pkg.M("a", 1, 2.5, true, Ident, name: [1, 2])`,
+			input: macro.Input{Args: []macro.Arg{
+				{Value: macro.Value{Kind: macro.KindString, String: "a"}},
+				{Value: macro.Value{Kind: macro.KindInt, Int: 1}},
+				{Value: macro.Value{Kind: macro.KindFloat, Float: 2.5}},
+				{Value: macro.Value{Kind: macro.KindBool, Bool: true}},
+				{Value: macro.Value{Kind: macro.KindIdent, Ident: "Ident"}},
+				{Name: "name", Value: macro.Value{Kind: macro.KindList, List: []macro.Value{
+					{Kind: macro.KindInt, Int: 1},
+					{Kind: macro.KindInt, Int: 2},
+				}}},
+			}},
+		},
+		{
+			text: `#pkg.M(list: [])`,
+			input: macro.Input{Args: []macro.Arg{
+				{Name: "list", Value: macro.Value{Kind: macro.KindList, List: []macro.Value{}}},
+			}},
+		},
+		{
+			text: `#pkg.M(a: 1, a: 2)`,
+			errs: []inputError{{msg: `duplicate argument "a"`, at: "a: 2"}},
+		},
+		{
+			text: `#pkg.M(a: 1, 2)`,
+			errs: []inputError{{msg: "positional argument after named arguments", at: "2)"}},
+		},
+		{
+			text: `#pkg.M(99999999999999999999)`,
+			errs: []inputError{{msg: "integer 99999999999999999999 out of range", at: "99999999999999999999"}},
+		},
+		{
+			text: `#pkg.M(1e999)`,
+			errs: []inputError{{msg: "float 1e999 out of range", at: "1e999"}},
+		},
+		{
+			text: `#pkg.M([1, "a", 2])`,
+			errs: []inputError{{msg: "list element of type string in a list of int", at: `"a"`}},
+		},
+	}
+
+	p, err := craft_parser.NewMacroASTParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			var (
+				line    = "// " + test.text
+				src     = "package p\n\n" + line + "\ntype T int\n"
+				fileSet = token.NewFileSet()
+				file    = fileSet.AddFile("p.go", fileSet.Base(), len(src))
+				c       = &Craft{Context: &Context{GoFile: "p.go"}, FileSet: fileSet}
+			)
+
+			file.SetLinesForContent([]byte(src))
+
+			// the text of a comment follows the //, as comment.Iter returns it
+			macroAST, err := p.ParseString("", line[2:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			commentPosition := fileSet.Position(file.Pos(strings.Index(src, "//") + 2))
+
+			input, ok := c.MacroInput(macroAST, commentPosition, fileSet.Position(file.Pos(strings.Index(src, "type"))))
+
+			if ok != (len(test.errs) == 0) {
+				t.Errorf("ok = %t with errors %v", ok, c.Errs)
+			}
+
+			if len(test.errs) == 0 {
+				if !reflect.DeepEqual(input, test.input) {
+					t.Errorf("input = %+v, want %+v", input, test.input)
+				}
+				return
+			}
+
+			if len(c.Errs) != len(test.errs) {
+				t.Fatalf("errors = %v, want %v", c.Errs, test.errs)
+			}
+
+			for i, err := range c.Errs {
+				want := test.errs[i]
+
+				if err.Msg != want.msg {
+					t.Errorf("error %d = %q, want %q", i, err.Msg, want.msg)
+				}
+
+				if at := line[err.MacroPosition.Column-1:]; err.MacroPosition.Line != 3 || !strings.HasPrefix(at, want.at) {
+					t.Errorf("error %q reported at %d:%d, want at %q on line 3", err.Msg, err.MacroPosition.Line, err.MacroPosition.Column, want.at)
+				}
+			}
+		})
+	}
+}
+
+type inputError struct {
+	msg string
+	at  string
+}
@@ -0,0 +1,161 @@
+package craft
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"github.com/aria3ppp/craft/protocol"
+)
+
+// currentPackage holds the parsed files of the current package.
+type currentPackage struct {
+	fileSet *token.FileSet
+	files   []*ast.File
+	err     error
+}
+
+// PackageFiles returns the non-test files of the current package matching
+// the default build context, parsed on first use. Files generated by craft
+// are left out. The current file is
+// returned as is, the others resolve positions through the returned
+// FileSet.
+func (c *Craft) PackageFiles() (*token.FileSet, []*ast.File, error) {
+	c.currentPackageOnce.Do(func() {
+		pkg := &currentPackage{
+			fileSet: token.NewFileSet(),
+		}
+
+		c.currentPackage = pkg
+
+		buildPkg, err := build.ImportDir(c.Context.PWD, 0)
+		if err != nil {
+			pkg.err = err
+			return
+		}
+
+		for _, goFile := range buildPkg.GoFiles {
+			if strings.HasSuffix(goFile, outputSuffix) {
+				continue
+			}
+
+			if goFile == filepath.Base(c.Context.GoFile) {
+				pkg.files = append(pkg.files, c.CurrentASTFile)
+				continue
+			}
+
+			file, err := parser.ParseFile(pkg.fileSet, filepath.Join(c.Context.PWD, goFile), nil, parser.ParseComments|parser.SkipObjectResolution)
+			if err != nil {
+				pkg.err = err
+				return
+			}
+
+			pkg.files = append(pkg.files, file)
+		}
+	})
+
+	return c.currentPackage.fileSet, c.currentPackage.files, c.currentPackage.err
+}
+
+// LookupConsts returns the constants of the named type declared in the
+// current package, in source order. A constant is of the type if it is
+// declared with the type, converted to it, or repeats such a declaration
+// implicitly in a const block, as with iota.
+func (c *Craft) LookupConsts(typeName string) ([]Const, error) {
+	fileSet, files, err := c.PackageFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var consts []Const
+
+	for _, file := range files {
+		position := fileSet.Position
+
+		if file == c.CurrentASTFile {
+			position = c.FileSet.Position
+		}
+
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.CONST {
+				continue
+			}
+
+			// whether the last spec with a type or values is of the type,
+			// which specs without either repeat
+			var typed bool
+
+			for _, spec := range genDecl.Specs {
+				valueSpec := spec.(*ast.ValueSpec)
+
+				if valueSpec.Type != nil || len(valueSpec.Values) > 0 {
+					typed = isConstOfType(valueSpec, typeName)
+				}
+
+				if !typed {
+					continue
+				}
+
+				doc := valueSpec.Doc
+				if doc == nil && !genDecl.Lparen.IsValid() {
+					doc = genDecl.Doc
+				}
+
+				for _, name := range valueSpec.Names {
+					if name.Name == "_" {
+						continue
+					}
+
+					consts = append(consts, Const{
+						Name:     name.Name,
+						Doc:      doc.Text(),
+						Comment:  valueSpec.Comment.Text(),
+						Position: position(name.Pos()),
+					})
+				}
+			}
+		}
+	}
+
+	return consts, nil
+}
+
+func isConstOfType(spec *ast.ValueSpec, typeName string) bool {
+	if spec.Type != nil {
+		ident, ok := spec.Type.(*ast.Ident)
+		return ok && ident.Name == typeName
+	}
+
+	// a conversion, e.g. const A = T(1)
+	call, ok := spec.Values[0].(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+
+	ident, ok := call.Fun.(*ast.Ident)
+	return ok && ident.Name == typeName
+}
+
+// protocolConsts returns the constants as sent to macro hosts. Positions are
+// relative to the module root.
+func (c *Craft) protocolConsts(consts []Const) []protocol.Const {
+	protocolConsts := make([]protocol.Const, 0, len(consts))
+
+	for _, constant := range consts {
+		position := constant.Position
+		position.Filename = filepath.ToSlash(filepath.Join(c.Context.RelativePath, filepath.Base(position.Filename)))
+
+		protocolConsts = append(protocolConsts, protocol.Const{
+			Name:     constant.Name,
+			Doc:      constant.Doc,
+			Comment:  constant.Comment,
+			Position: position,
+		})
+	}
+
+	return protocolConsts
+}
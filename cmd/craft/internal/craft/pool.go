@@ -0,0 +1,35 @@
+package craft
+
+import "sync"
+
+// Pool runs jobs on at most a fixed number of goroutines at a time.
+type Pool struct {
+	wg  sync.WaitGroup
+	sem chan struct{}
+}
+
+// NewPool returns a pool running at most size jobs at a time, or one at a
+// time if size is not positive.
+func NewPool(size int) *Pool {
+	return &Pool{sem: make(chan struct{}, max(size, 1))}
+}
+
+// Go runs the job on the pool. It blocks until the pool has room for it.
+func (p *Pool) Go(job func()) {
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+
+		job()
+	}()
+}
+
+// Wait waits for the jobs of the pool to finish.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
@@ -3,20 +3,86 @@ package craft
 import (
 	"go/token"
 
+	"github.com/aria3ppp/craft/macro"
 	craft_parser "github.com/aria3ppp/craft/parser"
+	"github.com/aria3ppp/craft/protocol"
 )
 
 type Process struct {
 	// GenDecl      *ast.GenDecl
 	// Spec         *ast.TypeSpec
 	// TypePosition token.Position
-	SourceName         string
-	UnexportedTypeName string
-	SourcePosition     token.Position
-	Macros             []*Macro
+	SourceName string
+	// VarTypeName is the name of the type of the variable SourceName if the
+	// macros are on a variable declaration, empty otherwise.
+	VarTypeName string
+	// Instantiation is true if the variable SourceName instantiates the
+	// generic type VarTypeName.
+	Instantiation bool
+	// Const is true if SourceName is a constant rather than a variable.
+	Const          bool
+	SourcePosition token.Position
+	// Source is the declaration of the type or function as returned by
+	// SpecSource, or empty if the type is not declared in the current file.
+	Source string
+	// Generic is true if the macros are on a generic type declaration, which
+	// has no reflect.Type.
+	Generic bool
+	// InPackage is true if the program must run inside the current package
+	// to reach SourceName, e.g. because it is unexported.
+	InPackage bool
+	// Func is true if the macros are on a function or method declaration.
+	// Receiver is the name of the receiver type of a method, which is a
+	// pointer type if PointerReceiver is true.
+	Func            bool
+	Receiver        string
+	PointerReceiver bool
+	// Fields are the fields of the struct type with macros on them.
+	Fields []Field
+	// Consts are the constants of the type declared in the current package.
+	Consts []Const
+	Macros []*Macro
 }
 
+// Const is a constant of the type of a process.
+type Const struct {
+	Name     string
+	Doc      string
+	Comment  string
+	Position token.Position
+}
+
+// Field is a struct field with macros on it. Index is the index of the field
+// in the struct type.
+type Field struct {
+	Name   string
+	Index  int
+	Macros []*Macro
+}
+
+// Invocation is a macro invocation on the declaration of a process, checked
+// to be runnable, and the request running it. Exec is the path of the
+// executable of an external macro, empty for a Go macro.
+//
+// MacroVersion is the version of the macro package, or the hash of the
+// executable of an external macro. Hash is the hash of the inputs of a Go
+// macro, recorded in its output. Fresh is true if the output was generated
+// from the same inputs, so the macro is not run.
+type Invocation struct {
+	Process      Process
+	Macro        *Macro
+	Request      protocol.Request
+	Exec         string
+	MacroVersion string
+	Hash         string
+	Fresh        bool
+}
+
+// Macro is a macro invocation. Text is the invocation as written in the
+// comment, without its leading '#'.
 type Macro struct {
 	AST           *craft_parser.MacroAST
+	Input         macro.Input
+	Text          string
 	MacroPosition token.Position
 }
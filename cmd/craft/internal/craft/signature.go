@@ -0,0 +1,318 @@
+package craft
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	macroPackageImportPath = "github.com/aria3ppp/craft/macro"
+	macroSignatures        = "func(string | macro.Input, reflect.Type | macro.TypeData) (string, error)"
+)
+
+// MacroInputKind is the type of the first parameter of a macro function.
+type MacroInputKind uint8
+
+const (
+	// MacroInputString macros take the raw string input of the invocation.
+	MacroInputString MacroInputKind = iota
+	// MacroInputArgs macros take the parsed arguments as a macro.Input.
+	MacroInputArgs
+)
+
+// MacroTypeKind is the type of the second parameter of a macro function.
+type MacroTypeKind uint8
+
+const (
+	// MacroTypeReflect macros take the reflect.Type of the type.
+	MacroTypeReflect MacroTypeKind = iota
+	// MacroTypeData macros take a macro.TypeData holding both the
+	// reflect.Type and the declaration of the type.
+	MacroTypeData
+)
+
+// MacroFunc describes the signature of a macro function.
+type MacroFunc struct {
+	Input MacroInputKind
+	Type  MacroTypeKind
+}
+
+// macroPackage holds the parsed source of a macro package so the macros it
+// exports can be checked before a program calling them is generated.
+type macroPackage struct {
+	importPath string
+	// version is the version of the module of the package, or the hash of
+	// the package source if the module is local and has none. It does not
+	// cover the packages the package imports, sourcesHash does.
+	version string
+	fileSet *token.FileSet
+	files   []*ast.File
+}
+
+type goListPackage struct {
+	Dir     string        `json:"Dir"`
+	Name    string        `json:"Name"`
+	GoFiles []string      `json:"GoFiles"`
+	Module  *goListModule `json:"Module"`
+}
+
+type goListModule struct {
+	Path    string        `json:"Path"`
+	Version string        `json:"Version"`
+	Replace *goListModule `json:"Replace"`
+}
+
+// CheckMacro reports an error if the macro package does not export a
+// function named by the macro invocation with one of the supported
+// signatures, or if the invocation does not fit the signature.
+func (c *Craft) CheckMacro(ctx context.Context, macro *Macro) (MacroFunc, error) {
+	importPath := c.Context.PackageImport(macro.AST.Package)
+
+	pkg, err := c.loadMacroPackage(ctx, importPath)
+	if err != nil {
+		return MacroFunc{}, err
+	}
+
+	macroFunc, err := pkg.lookupMacro(macro.AST.Macro)
+	if err != nil {
+		return MacroFunc{}, err
+	}
+
+	if macroFunc.Input == MacroInputString && len(macro.Input.Args) > 0 {
+		return MacroFunc{}, fmt.Errorf("macro %q takes a string input and does not accept arguments", macro.AST.Macro)
+	}
+
+	return macroFunc, nil
+}
+
+func (c *Craft) loadMacroPackage(ctx context.Context, importPath string) (*macroPackage, error) {
+	c.macroPackagesMu.Lock()
+	defer c.macroPackagesMu.Unlock()
+
+	if pkg, exists := c.macroPackages[importPath]; exists {
+		return pkg, nil
+	}
+
+	var goListCmdOut, goListCmdErr bytes.Buffer
+
+	goListCmd := Command(ctx, "go", "list", "-json", importPath)
+	goListCmd.Dir = c.Context.PWD
+	goListCmd.Stdout = &goListCmdOut
+	goListCmd.Stderr = &goListCmdErr
+
+	if err := goListCmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, errors.New(strings.TrimSpace(goListCmdErr.String()))
+		}
+		return nil, fmt.Errorf("[INTERNAL ERROR] [file a bug] failed to list macro package %q: %s", importPath, err)
+	}
+
+	var listed goListPackage
+	if err := json.Unmarshal(goListCmdOut.Bytes(), &listed); err != nil {
+		return nil, fmt.Errorf("[INTERNAL ERROR] [file a bug] failed to decode macro package %q: %s", importPath, err)
+	}
+
+	pkg := &macroPackage{
+		importPath: importPath,
+		fileSet:    token.NewFileSet(),
+	}
+
+	// the source of a local module changes without its version
+	local := listed.Module == nil || listed.Module.Version == "" ||
+		(listed.Module.Replace != nil && listed.Module.Replace.Version == "")
+	sourceHash := sha256.New()
+
+	for _, goFile := range listed.GoFiles {
+		filename := filepath.Join(listed.Dir, goFile)
+
+		file, err := parser.ParseFile(pkg.fileSet, filename, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing macro package %q: %s", importPath, err)
+		}
+
+		pkg.files = append(pkg.files, file)
+
+		if local {
+			if err := hashFile(sourceHash, filename); err != nil {
+				return nil, fmt.Errorf("failed hashing macro package %q: %s", importPath, err)
+			}
+		}
+	}
+
+	if local {
+		pkg.version = "sha256:" + hex.EncodeToString(sourceHash.Sum(nil))
+	} else {
+		pkg.version = listed.Module.Version
+	}
+
+	if c.macroPackages == nil {
+		c.macroPackages = make(map[string]*macroPackage)
+	}
+	c.macroPackages[importPath] = pkg
+
+	return pkg, nil
+}
+
+func (p *macroPackage) lookupMacro(name string) (MacroFunc, error) {
+	for _, file := range p.files {
+		names := importNames{
+			reflect: importName(file, "reflect"),
+			macro:   importName(file, macroPackageImportPath),
+		}
+
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv != nil || d.Name.Name != name {
+					continue
+				}
+
+				return p.checkSignature(name, d.Type, names)
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					valueSpec, ok := spec.(*ast.ValueSpec)
+					if !ok {
+						continue
+					}
+
+					for i, ident := range valueSpec.Names {
+						if ident.Name != name {
+							continue
+						}
+
+						if funcType, ok := valueSpec.Type.(*ast.FuncType); ok {
+							return p.checkSignature(name, funcType, names)
+						}
+
+						if i < len(valueSpec.Values) {
+							if funcLit, ok := valueSpec.Values[i].(*ast.FuncLit); ok {
+								return p.checkSignature(name, funcLit.Type, names)
+							}
+						}
+
+						return MacroFunc{}, fmt.Errorf("macro %q in package %q is not a function", name, p.importPath)
+					}
+				}
+			}
+		}
+	}
+
+	return MacroFunc{}, fmt.Errorf("macro %q not found in package %q", name, p.importPath)
+}
+
+// importNames are the names a macro package file refers to the packages
+// used in macro signatures with.
+type importNames struct {
+	reflect string
+	macro   string
+}
+
+func (p *macroPackage) checkSignature(name string, funcType *ast.FuncType, names importNames) (MacroFunc, error) {
+	var (
+		inputs = map[MacroInputKind]func(ast.Expr) bool{
+			MacroInputString: isIdent("string"),
+			MacroInputArgs:   isSelector(names.macro, "Input"),
+		}
+		types = map[MacroTypeKind]func(ast.Expr) bool{
+			MacroTypeReflect: isSelector(names.reflect, "Type"),
+			MacroTypeData:    isSelector(names.macro, "TypeData"),
+		}
+	)
+
+	if funcType.TypeParams == nil && matchTypes(funcType.Results, isIdent("string"), isIdent("error")) {
+		for inputKind, matchInput := range inputs {
+			for typeKind, matchType := range types {
+				if matchTypes(funcType.Params, matchInput, matchType) {
+					return MacroFunc{Input: inputKind, Type: typeKind}, nil
+				}
+			}
+		}
+	}
+
+	var signature bytes.Buffer
+	if err := printer.Fprint(&signature, p.fileSet, funcType); err != nil {
+		return MacroFunc{}, fmt.Errorf("[INTERNAL ERROR] [file a bug] failed to print signature of macro %q: %s", name, err)
+	}
+
+	return MacroFunc{}, fmt.Errorf("macro %q in package %q has signature %s; want %s", name, p.importPath, signature.String(), macroSignatures)
+}
+
+// matchTypes reports whether the flattened types of the field list match
+// the given predicates one by one.
+func matchTypes(fields *ast.FieldList, matchers ...func(ast.Expr) bool) bool {
+	var types []ast.Expr
+
+	if fields != nil {
+		for _, field := range fields.List {
+			n := max(len(field.Names), 1)
+			for range n {
+				types = append(types, field.Type)
+			}
+		}
+	}
+
+	if len(types) != len(matchers) {
+		return false
+	}
+
+	for i, match := range matchers {
+		if !match(types[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isIdent(name string) func(ast.Expr) bool {
+	return func(expr ast.Expr) bool {
+		ident, ok := expr.(*ast.Ident)
+		return ok && ident.Name == name
+	}
+}
+
+func isSelector(pkg, name string) func(ast.Expr) bool {
+	return func(expr ast.Expr) bool {
+		selector, ok := expr.(*ast.SelectorExpr)
+		if !ok || selector.Sel.Name != name {
+			return false
+		}
+
+		ident, ok := selector.X.(*ast.Ident)
+		return ok && ident.Name == pkg
+	}
+}
+
+// importName returns the name the file refers to the import path with, or an
+// empty string if the file does not import it.
+func importName(file *ast.File, pkgPath string) string {
+	for _, imp := range file.Imports {
+		importPath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || importPath != pkgPath {
+			continue
+		}
+
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+
+		return path.Base(importPath)
+	}
+
+	return ""
+}
@@ -0,0 +1,176 @@
+package craft
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestLookupMacro(t *testing.T) {
+	const src = `package m
+
+import (
+	"reflect"
+
+	"github.com/aria3ppp/craft/macro"
+)
+
+func Func(input string, typ reflect.Type) (string, error) { return "", nil }
+
+func Args(input macro.Input, data macro.TypeData) (string, error) { return "", nil }
+
+var Lit = func(input macro.Input, typ reflect.Type) (string, error) { return "", nil }
+
+var Typed func(input string, data macro.TypeData) (string, error)
+
+var Value = 1
+
+type Type struct{}
+
+func (Type) Method(input string, typ reflect.Type) (string, error) { return "", nil }
+
+func Generic[T any](input string, typ reflect.Type) (string, error) { return "", nil }
+
+func Wrong(input string) (string, error) { return "", nil }
+`
+
+	tests := []struct {
+		name string
+		want MacroFunc
+		err  string
+	}{
+		{name: "Func", want: MacroFunc{Input: MacroInputString, Type: MacroTypeReflect}},
+		{name: "Args", want: MacroFunc{Input: MacroInputArgs, Type: MacroTypeData}},
+		{name: "Lit", want: MacroFunc{Input: MacroInputArgs, Type: MacroTypeReflect}},
+		{name: "Typed", want: MacroFunc{Input: MacroInputString, Type: MacroTypeData}},
+		{name: "Value", err: `macro "Value" in package "example.com/m" is not a function`},
+		{name: "Type", err: `macro "Type" not found in package "example.com/m"`},
+		{name: "Method", err: `macro "Method" not found in package "example.com/m"`},
+		{name: "Missing", err: `macro "Missing" not found in package "example.com/m"`},
+		{
+			name: "Generic",
+			err: `macro "Generic" in package "example.com/m" has signature ` +
+				`func[T any](input string, typ reflect.Type) (string, error); want ` + macroSignatures,
+		},
+		{
+			name: "Wrong",
+			err: `macro "Wrong" in package "example.com/m" has signature ` +
+				`func(input string) (string, error); want ` + macroSignatures,
+		},
+	}
+
+	pkg := parseMacroPackage(t, src)
+
+	for _, test := range tests {
+		got, err := pkg.lookupMacro(test.name)
+
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("lookupMacro(%q) error = %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("lookupMacro(%q): %s", test.name, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("lookupMacro(%q) = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLookupMacroImportNames(t *testing.T) {
+	const src = `package m
+
+import (
+	refl "reflect"
+
+	craft "github.com/aria3ppp/craft/macro"
+)
+
+func Reflect(input craft.Input, typ refl.Type) (string, error) { return "", nil }
+
+func Data(input string, data craft.TypeData) (string, error) { return "", nil }
+
+func Unaliased(input macro.Input, typ reflect.Type) (string, error) { return "", nil }
+`
+
+	pkg := parseMacroPackage(t, src)
+
+	got, err := pkg.lookupMacro("Reflect")
+	if err != nil {
+		t.Fatalf("lookupMacro(%q): %s", "Reflect", err)
+	}
+
+	if want := (MacroFunc{Input: MacroInputArgs, Type: MacroTypeReflect}); got != want {
+		t.Errorf("lookupMacro(%q) = %+v, want %+v", "Reflect", got, want)
+	}
+
+	got, err = pkg.lookupMacro("Data")
+	if err != nil {
+		t.Fatalf("lookupMacro(%q): %s", "Data", err)
+	}
+
+	if want := (MacroFunc{Input: MacroInputString, Type: MacroTypeData}); got != want {
+		t.Errorf("lookupMacro(%q) = %+v, want %+v", "Data", got, want)
+	}
+
+	// the file refers to the packages with their aliases only
+	if _, err := pkg.lookupMacro("Unaliased"); err == nil {
+		t.Errorf("lookupMacro(%q): no error", "Unaliased")
+	}
+}
+
+func TestImportName(t *testing.T) {
+	const src = `package m
+
+import (
+	"encoding/json"
+	refl "reflect"
+	_ "embed"
+)
+`
+
+	file, err := parser.ParseFile(token.NewFileSet(), "m.go", src, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "encoding/json", want: "json"},
+		{path: "reflect", want: "refl"},
+		{path: "embed", want: "_"},
+		{path: macroPackageImportPath, want: ""},
+	}
+
+	for _, test := range tests {
+		if got := importName(file, test.path); got != test.want {
+			t.Errorf("importName(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func parseMacroPackage(t *testing.T, src string) *macroPackage {
+	t.Helper()
+
+	pkg := &macroPackage{
+		importPath: "example.com/m",
+		fileSet:    token.NewFileSet(),
+	}
+
+	file, err := parser.ParseFile(pkg.fileSet, "m.go", src, parser.SkipObjectResolution)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg.files = []*ast.File{file}
+
+	return pkg
+}
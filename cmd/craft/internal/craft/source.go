@@ -0,0 +1,82 @@
+package craft
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// SpecSource returns the source of a spec and its doc comment as a file of
+// its own. The spec keeps its line and column, so positions in the parsed
+// file are the positions in the current file.
+func (c *Craft) SpecSource(
+	genDecl *ast.GenDecl,
+	spec ast.Spec,
+	doc *ast.CommentGroup,
+) string {
+	grouped := genDecl.Lparen.IsValid()
+
+	start := genDecl.Pos()
+	if grouped {
+		start = spec.Pos()
+	}
+
+	if doc != nil && doc.Pos() < start {
+		start = doc.Pos()
+	}
+
+	end := spec.End()
+
+	if typeSpec, ok := spec.(*ast.TypeSpec); ok && typeSpec.Comment != nil {
+		end = typeSpec.Comment.End()
+	}
+
+	if valueSpec, ok := spec.(*ast.ValueSpec); ok && valueSpec.Comment != nil {
+		end = valueSpec.Comment.End()
+	}
+
+	var group string
+	if grouped {
+		group = genDecl.Tok.String()
+	}
+
+	return c.nodeSource(start, end, group)
+}
+
+// nodeSource returns the current file source between start and end, prefixed
+// with the package clause and padded to its original line and column. A
+// non-empty group wraps the source in a group of the given declaration
+// keyword.
+func (c *Craft) nodeSource(start, end token.Pos, group string) string {
+	var (
+		file          = c.FileSet.File(start)
+		startPosition = file.Position(start)
+		line          = max(startPosition.Line, 2)
+		src           strings.Builder
+	)
+
+	if group != "" {
+		line = max(line, 3)
+	}
+
+	src.WriteString("package ")
+	src.WriteString(c.CurrentASTFile.Name.Name)
+	src.WriteString(strings.Repeat("\n", line-2))
+
+	if group != "" {
+		src.WriteString(group)
+		src.WriteString(" (")
+	}
+
+	src.WriteString("\n")
+	src.WriteString(strings.Repeat(" ", startPosition.Column-1))
+	src.Write(c.CurrentSource[file.Offset(start):file.Offset(end)])
+
+	if group != "" {
+		src.WriteString("\n)")
+	}
+
+	src.WriteString("\n")
+
+	return src.String()
+}
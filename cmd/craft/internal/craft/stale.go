@@ -0,0 +1,103 @@
+package craft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// StaleOutputs returns the generated Go files of the current directory that
+// the header attributes to the current file but that none of the macros of
+// the processes generates, e.g. because the macro invocation was removed or
+// the declaration renamed. It is called before the macros run, so the stale
+// files, which may refer to declarations that no longer exist, can be left
+// out of the build of the macro host.
+//
+// The outputs craft names are stale unless a macro still generates them.
+// The other files of external macros are not known before they run, so they
+// are stale once no macro of the file has the invocation recorded in their
+// header.
+func (c *Craft) StaleOutputs(processes []Process) []string {
+	var (
+		outputs     []string
+		invocations []string
+	)
+
+	for _, process := range processes {
+		for _, macro := range process.Macros {
+			// the header records the path of an external macro as given
+			macroPath := c.Context.PackageImport(macro.AST.Package)
+			if execPath, isExec := c.Context.ExecMacro(macro.AST.Package); isExec {
+				macroPath = execPath
+			}
+
+			outputs = append(outputs, filepath.Join(c.Context.PWD, outputFile(process, macro)))
+			invocations = append(invocations, macroPath+" "+invocationText(macro))
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(c.Context.PWD, "*.go"))
+	if err != nil {
+		fmt.Printf("[INTERNAL ERROR] [file a bug] failed to list the generated files: %s\n", err)
+		return nil
+	}
+
+	var stale []string
+
+	for _, path := range paths {
+		if slices.Contains(outputs, path) {
+			continue
+		}
+
+		header, ok := readHeader(path)
+		if !ok || header.Source != c.Context.GoFile {
+			continue
+		}
+
+		if !strings.HasSuffix(path, outputSuffix) && slices.Contains(invocations, header.Macro+" "+header.Invocation) {
+			continue
+		}
+
+		stale = append(stale, path)
+	}
+
+	return stale
+}
+
+// RemoveStaleOutputs removes the stale generated files returned by
+// StaleOutputs, except those a macro generated again in the run, e.g. a file
+// of an external macro whose invocation changed. It only reports them if
+// DryRun or Check is set, and must otherwise only be called after a
+// successful run.
+func (c *Craft) RemoveStaleOutputs(stale []string) {
+	for _, path := range stale {
+		if c.generated[path] {
+			continue
+		}
+
+		relPath := filepath.Join(c.Context.RelativePath, filepath.Base(path))
+
+		// files of older versions of craft do not record their invocation
+		if header, ok := readHeader(path); ok && header.Invocation != "" {
+			relPath += fmt.Sprintf(" (%s at %s:%d)", header.Invocation, header.Source, header.Line)
+		}
+
+		if c.Context.Check {
+			c.Drift.Add(1)
+		}
+
+		if c.Context.DryRun || c.Context.Check {
+			fmt.Printf("stale: %s\n", relPath)
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			fmt.Printf("error: failed to remove stale %s: %s\n", relPath, err)
+			continue
+		}
+
+		fmt.Printf("removed stale %s\n", relPath)
+	}
+}
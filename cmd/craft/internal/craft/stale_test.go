@@ -0,0 +1,90 @@
+package craft
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	craft_parser "github.com/aria3ppp/craft/parser"
+)
+
+func TestStaleOutputs(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		// generated by a macro of the file
+		"Foo_m_Gen.crafted.go": header{Source: "a.go", Line: 3, Invocation: "m.Gen(``)"}.String() + "\npackage a\n",
+		// generated for a renamed declaration
+		"Old_m_Gen.crafted.go": header{Source: "a.go", Line: 3, Invocation: "m.Gen(``)"}.String() + "\npackage a\n",
+		// generated by an older version of craft, without a line
+		"Older_m_Gen.crafted.go": generatedHeader + "\n" + sourceHeaderPrefix + "a.go\n\npackage a\n",
+		// generated for another file
+		"Bar_m_Gen.crafted.go": header{Source: "b.go", Line: 3, Invocation: "m.Gen(``)"}.String() + "\npackage a\n",
+		// not generated by craft
+		"Hand_m_Gen.crafted.go": "package a\n",
+		// generated along with an output by a macro of the file
+		"types.go": header{Source: "a.go", Line: 3, Invocation: "m.Gen(``)", Macro: "example.com/m"}.String() + "\npackage a\n",
+		// generated along with an output by a removed macro
+		"old_types.go": header{Source: "a.go", Line: 3, Invocation: "m.Old(``)", Macro: "example.com/m"}.String() + "\npackage a\n",
+		"a.go":         "package a\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &Craft{
+		Context: &Context{
+			MacroPackageImports: map[string]string{"m": "example.com/m"},
+			GoFile:              "a.go",
+			PWD:                 dir,
+		},
+	}
+
+	processes := []Process{
+		{
+			SourceName: "Foo",
+			Macros: []*Macro{
+				{AST: &craft_parser.MacroAST{Package: "m", Macro: "Gen"}, Text: "m.Gen(``)"},
+			},
+		},
+	}
+
+	stale := c.StaleOutputs(processes)
+
+	want := []string{
+		filepath.Join(dir, "Old_m_Gen.crafted.go"),
+		filepath.Join(dir, "Older_m_Gen.crafted.go"),
+		filepath.Join(dir, "old_types.go"),
+	}
+
+	slices.Sort(stale)
+
+	if !slices.Equal(stale, want) {
+		t.Fatalf("StaleOutputs() = %q, want %q", stale, want)
+	}
+
+	c.Context.DryRun = true
+	c.RemoveStaleOutputs(stale)
+
+	for _, path := range stale {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("dry run removed %s: %s", path, err)
+		}
+	}
+
+	c.Context.DryRun = false
+	c.RemoveStaleOutputs(stale)
+
+	for name := range files {
+		path := filepath.Join(dir, name)
+
+		_, err := os.Stat(path)
+		if removed := err != nil; removed != slices.Contains(stale, path) {
+			t.Errorf("%s removed = %t, want %t", name, removed, !removed)
+		}
+	}
+}
@@ -2,33 +2,53 @@ package craft
 
 import (
 	_ "embed"
-
-	craft_error "github.com/aria3ppp/craft/error"
 )
 
 //go:embed program.template
 var programTemplate string
 
+// TemplateDate is the data of the macro host of the current file.
 type TemplateDate struct {
-	OutputFilePath  string
-	ValueDefinition string
-	SourceName      string
-	TypeName        string
-	SourcePosition  craft_error.Position
-	Macro           TemplateDataMacro
-	Package         TemplateDataPackage
+	// InPackage hosts are generated as a test named TestName of the current
+	// package instead of a main package importing it.
+	InPackage     bool
+	TestName      string
+	UsesReflect   bool
+	MacroPackages []TemplateDataMacroPackage
+	Macros        []TemplateDataMacro
+	Declarations  []TemplateDataDeclaration
+	Package       TemplateDataPackage
 }
 
+// TemplateDataMacroPackage is a macro package the host imports as Alias.
+type TemplateDataMacroPackage struct {
+	Alias      string
+	ImportPath string
+}
+
+// TemplateDataMacro is a macro function the host registers by Key.
 type TemplateDataMacro struct {
+	Key          string
+	Name         string
+	PackageAlias string
+}
+
+// TemplateDataDeclaration is a declaration the host registers by Name.
+// TypeOfExpr is empty for a generic declaration.
+type TemplateDataDeclaration struct {
 	Name       string
-	ImportPath string
-	Input      string
-	Position   craft_error.Position
+	TypeOfExpr string
+	Consts     []TemplateDataConst
+}
+
+// TemplateDataConst is a constant of a declaration and the expression of
+// its value.
+type TemplateDataConst struct {
+	Name string
+	Expr string
 }
 
 type TemplateDataPackage struct {
-	RelativePath         string
-	GoFile               string
 	ImportPathDefinition string
 	Name                 string
 }
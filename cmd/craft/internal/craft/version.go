@@ -0,0 +1,36 @@
+package craft
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// Version returns the version of craft: its module version, along with the
+// revision it was built from if it was built from a checkout.
+var Version = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+
+	var revision, modified string
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "+dirty"
+			}
+		}
+	}
+
+	if revision != "" {
+		version += " " + revision + modified
+	}
+
+	return version
+})
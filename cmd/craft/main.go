@@ -1,42 +1,106 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	go_token "go/token"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 
 	"github.com/aria3ppp/craft/cmd/craft/internal/craft"
 	craft_error "github.com/aria3ppp/craft/error"
 	craft_parser "github.com/aria3ppp/craft/parser"
-	"github.com/samber/lo"
 )
 
-var macroPackageImports map[string]string
+var (
+	macroPackageImports map[string]string
+	// macroPackageVersions are the versions macro packages are pinned to
+	// with import-path@version, by import path
+	macroPackageVersions map[string]string
+	verbose              bool
+	jobs                 int
+	timeout              time.Duration
+	macroTimeout         time.Duration
+	force                bool
+	dryRun               bool
+	// command is the subcommand run instead of the macros, if any
+	command string
+)
+
+// parseFlags parses the command line into the flags, the subcommand and the
+// macro packages.
+func parseFlags() {
+	flag.BoolVar(&verbose, "v", false, "print the macros run and the cache statistics")
+	flag.IntVar(&jobs, "j", runtime.GOMAXPROCS(0), "the number of declarations parsed and macros run at a time")
+	flag.DurationVar(&timeout, "timeout", 0, "kill craft and the macros it runs after this long; 0 for no limit")
+	flag.DurationVar(&macroTimeout, "macro-timeout", 0, "kill a macro running for longer than this; 0 for no limit")
+	flag.BoolVar(&force, "force", false, "run every macro, even those whose outputs are up to date")
+	flag.BoolVar(&dryRun, "dry-run", false, "report the stale outputs of the file instead of removing them")
+
+	flag.Usage = func() {
+		fmt.Printf("usage: %s [flags] <import-path>...\n", os.Args[0])
+		fmt.Printf("       %s check [flags] <import-path>...\n", os.Args[0])
+		fmt.Printf("       %s deps [alias=]<import-path>[@version]...\n", os.Args[0])
+		fmt.Printf("       %s clean [-cache]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.Arg(0) == "deps" || flag.Arg(0) == "clean" {
+		command = flag.Arg(0)
+		return
+	}
+
+	// check takes the flags and macro packages of a run
+	if flag.Arg(0) == "check" {
+		command = flag.Arg(0)
+		_ = flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
+	if jobs < 1 {
+		fmt.Printf("error: -j must be at least 1, got %d\n", jobs)
+		os.Exit(1)
+	}
 
-func init() {
-	if len(os.Args) < 2 {
+	if flag.NArg() < 1 {
 		fmt.Printf("error: a macro import path must be provided!\n")
-		fmt.Printf("usage: %s <import-path>\n", os.Args[0])
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	macroPackageImports = make(map[string]string, len(os.Args)-1)
+	macroPackageImports = make(map[string]string, flag.NArg())
+	macroPackageVersions = make(map[string]string)
 
-	for _, arg := range os.Args[1:] {
+	for _, arg := range flag.Args() {
 		pkg, importURL, hasAlias := strings.Cut(arg, "=")
 		if !hasAlias {
-			pkg = path.Base(arg)
 			importURL = arg
 		}
 
+		if !strings.HasPrefix(importURL, craft.ExecPrefix) {
+			var version string
+
+			importURL, version, _ = strings.Cut(importURL, "@")
+			if version != "" {
+				macroPackageVersions[importURL] = version
+			}
+		}
+
+		if !hasAlias {
+			pkg = path.Base(importURL)
+		}
+
 		if _, exists := macroPackageImports[pkg]; exists {
 			fmt.Printf("error: macro package %q already exists!\n", pkg)
 			fmt.Printf("distinguish this macro package with an alias:\n\te.g: zoo=foo/bar/baz\n")
@@ -48,22 +112,47 @@ func init() {
 }
 
 func main() {
+	parseFlags()
+
+	// an interruption cancels the macros run, so craft can kill them and
+	// remove its temporary files; a second one kills craft
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	context.AfterFunc(ctx, stop)
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("timed out after %s", timeout))
+		defer cancel()
+	}
+
+	switch command {
+	case "clean":
+		clean(flag.Args()[1:])
+		return
+	case "deps":
+		deps(ctx, flag.Args()[1:])
+		return
+	}
+
 	var (
 		gofile  = os.Getenv("GOFILE")
 		pwd     = os.Getenv("PWD")
 		fileSet = go_token.NewFileSet()
 	)
 
-	astFile, err := parser.ParseFile(fileSet, gofile, nil, parser.ParseComments)
+	source, err := os.ReadFile(gofile)
 	if err != nil {
-		fmt.Printf("craft internal error: failed parsing %q: %s\n", gofile, err)
+		fmt.Printf("craft internal error: failed reading %q: %s\n", gofile, err)
 		os.Exit(1)
 		return
 	}
 
-	// TODO: currently macro does not support package main
-	if astFile.Name.Name == "main" {
-		fmt.Printf("craft currently does not support macros from package main\n")
+	astFile, err := parser.ParseFile(fileSet, gofile, source, parser.ParseComments)
+	if err != nil {
+		fmt.Printf("craft internal error: failed parsing %q: %s\n", gofile, err)
 		os.Exit(1)
 		return
 	}
@@ -75,7 +164,7 @@ func main() {
 		return
 	}
 
-	mod, err := modInfo()
+	mod, err := modInfo(ctx, pwd)
 	if err != nil {
 		fmt.Printf("craft internal error: failed to get mod info: %s\n", err)
 		os.Exit(1)
@@ -89,6 +178,11 @@ func main() {
 		return
 	}
 
+	cacheDir, err := craft.CacheDir()
+	if err != nil && verbose {
+		fmt.Printf("cache: disabled: %s\n", err)
+	}
+
 	c := &craft.Craft{
 		Context: &craft.Context{
 			MacroPackageImports:  macroPackageImports,
@@ -96,32 +190,40 @@ func main() {
 			RelativePath:         relativePath,
 			CurrentPkgImportPath: currentPkgImportPath,
 			PWD:                  pwd,
+			CacheDir:             cacheDir,
+			Verbose:              verbose,
+			Jobs:                 jobs,
+			MacroTimeout:         macroTimeout,
+			Force:                force || command == "check",
+			DryRun:               dryRun,
+			Check:                command == "check",
 		},
 		CurrentASTFile: astFile,
+		CurrentSource:  source,
 		FileSet:        fileSet,
 		Parser:         macroASTParser,
 		Processes:      nil,
 		Errs:           nil,
 	}
 
-	wg := &sync.WaitGroup{}
+	pool := craft.NewPool(jobs)
 
 	for _, decl := range astFile.Decls {
 		switch d := decl.(type) {
 		case *ast.GenDecl:
 			for _, spec := range d.Specs {
-				wg.Add(1)
-
-				go func() {
-					defer wg.Done()
-
+				pool.Go(func() {
 					c.HandleMacrosOnSpec(d, spec)
-				}()
+				})
 			}
+		case *ast.FuncDecl:
+			pool.Go(func() {
+				c.HandleMacrosOnFuncDecl(d)
+			})
 		}
 	}
 
-	wg.Wait()
+	pool.Wait()
 
 	if len(c.Errs) != 0 {
 		handleErrors(c.Errs)
@@ -129,20 +231,59 @@ func main() {
 		return
 	}
 
+	// stale outputs are found before the macros run, as they may refer to
+	// declarations that no longer exist and would break the build of the
+	// macro host
+	var (
+		stale = c.StaleOutputs(c.Processes)
+		ok    = true
+	)
+
 	if len(c.Processes) > 0 {
-		getDependencies()
+		checkDependencies(ctx)
 
-		for _, process := range c.Processes {
-			wg.Add(1)
+		ok = c.HandleProcesses(ctx, c.Processes, stale)
 
-			go func() {
-				defer wg.Done()
+		if ctx.Err() != nil {
+			stop()
+			os.Exit(1)
+		}
+
+		if verbose {
+			fmt.Printf(
+				"cache: %d up to date, %d hits, %d misses, %d fresh macros\n",
+				c.CacheStats.UpToDate.Load(),
+				c.CacheStats.Hits.Load(),
+				c.CacheStats.Misses.Load(),
+				c.CacheStats.Fresh.Load(),
+			)
+		}
+	}
 
-				c.HandleProcess(process)
-			}()
+	if cacheDir != "" {
+		if err := craft.TrimCache(cacheDir); err != nil && verbose {
+			fmt.Printf("cache: failed to trim: %s\n", err)
 		}
+	}
 
-		wg.Wait()
+	// stale outputs are only removed after a successful run, as a macro
+	// that failed may still be meant to generate them, but always reported
+	if ok || dryRun || command == "check" {
+		c.RemoveStaleOutputs(stale)
+	}
+
+	sourcePath := filepath.Join(relativePath, gofile)
+
+	// a failed macro fails the run, so go generate and CI fail too
+	switch {
+	case !ok && command == "check":
+		fmt.Printf("check: the macros of %s failed\n", sourcePath)
+		os.Exit(1)
+	case !ok:
+		os.Exit(1)
+	case command == "check" && c.Drift.Load() > 0:
+		fmt.Printf("check: %d generated files of %s are out of date\n", c.Drift.Load(), sourcePath)
+		os.Exit(1)
 	}
 }
 
@@ -158,31 +299,3 @@ func handleErrors(errs []craft_error.Error) {
 		fmt.Println(err.Error())
 	}
 }
-
-func getDependencies() {
-	fmt.Println("downloading dependencies...")
-
-	deps := lo.Values(macroPackageImports)
-	slices.Sort(deps)
-
-	var goGetCmdOut bytes.Buffer
-	args := append([]string{"get", "-u"}, deps...)
-	goGetCmd := exec.Command("go", args...)
-	goGetCmd.Stdout = &goGetCmdOut
-	goGetCmd.Stderr = &goGetCmdOut
-
-	if err := goGetCmd.Run(); err != nil {
-		var msg string
-
-		switch err.(type) {
-		default:
-			msg = fmt.Sprintf("[INTERNAL ERROR] [file a bug] failed to get dependencies: %s", err)
-		case *exec.ExitError:
-			msg = goGetCmdOut.String()
-		}
-
-		fmt.Println(msg)
-
-		os.Exit(1)
-	}
-}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"os/exec"
+	"fmt"
+	"io"
 	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/aria3ppp/craft/cmd/craft/internal/craft"
 )
 
 type Module struct {
 	Path  string `json:"Path"`
+	Dir   string `json:"Dir"`
 	GoMod string `json:"GoMod"`
 }
 
@@ -25,21 +31,63 @@ func (m Module) Validate() (Module, error) {
 	return m, nil
 }
 
-func modInfo() (m Module, err error) {
-	var jsonBytes []byte
+// modInfo returns the main module containing pwd. In a go.work workspace
+// every module of the workspace is a main module, so the one containing pwd
+// most closely is picked.
+func modInfo(ctx context.Context, pwd string) (m Module, err error) {
+	cmd := craft.Command(ctx, "go", "list", "-m", "-json")
+	cmd.Dir = pwd
 
-	jsonBytes, err = exec.Command("go", "list", "-m", "-json").Output()
+	jsonBytes, err := cmd.Output()
 	if err != nil {
 		return Module{}, err
 	}
 
-	if err := json.Unmarshal(jsonBytes, &m); err != nil {
-		return Module{}, err
+	var (
+		decoder = json.NewDecoder(bytes.NewReader(jsonBytes))
+		found   bool
+	)
+
+	for {
+		var module Module
+
+		if err := decoder.Decode(&module); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return Module{}, err
+		}
+
+		if module.Dir == "" && module.GoMod != "" {
+			module.Dir = filepath.Dir(module.GoMod)
+		}
+
+		if !containsDir(module.Dir, pwd) || (found && len(module.Dir) <= len(m.Dir)) {
+			continue
+		}
+
+		m = module
+		found = true
+	}
+
+	if !found {
+		return Module{}, fmt.Errorf("no module of the workspace contains %s", pwd)
 	}
 
 	return m.Validate()
 }
 
+// containsDir reports whether dir is parent or a directory in it.
+func containsDir(parent, dir string) bool {
+	if parent == "" {
+		return false
+	}
+
+	rel, err := filepath.Rel(parent, dir)
+
+	return err == nil && filepath.IsLocal(rel)
+}
+
 func relativePathFromRoot(
 	pwd string,
 	mod Module,
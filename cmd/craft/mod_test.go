@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContainsDir(t *testing.T) {
+	tests := []struct {
+		parent string
+		dir    string
+		want   bool
+	}{
+		{parent: "/a", dir: "/a", want: true},
+		{parent: "/a", dir: "/a/b/c", want: true},
+		{parent: "/a", dir: "/ab", want: false},
+		{parent: "/a/b", dir: "/a", want: false},
+		{parent: "/a", dir: "/b", want: false},
+		{parent: "", dir: "/a", want: false},
+	}
+
+	for _, test := range tests {
+		parent, dir := filepath.FromSlash(test.parent), filepath.FromSlash(test.dir)
+
+		if got := containsDir(parent, dir); got != test.want {
+			t.Errorf("containsDir(%q, %q) = %t, want %t", parent, dir, got, test.want)
+		}
+	}
+}
+
+func TestModInfoWorkspace(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOWORK", "")
+
+	root := t.TempDir()
+
+	files := map[string]string{
+		"go.work":                "go 1.22\n\nuse (\n\t./a\n\t./a/b\n)\n",
+		"a/go.mod":               "module example.com/a\n\ngo 1.22\n",
+		"a/sub/sub.go":           "package sub\n",
+		"a/b/go.mod":             "module example.com/b\n\ngo 1.22\n",
+		"a/b/c/c.go":             "package c\n",
+		"outside/outside.go":     "package outside\n",
+		"a/sub/nested/nested.go": "package nested\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pwd  string
+		want string
+	}{
+		{pwd: "a", want: "example.com/a"},
+		{pwd: "a/sub/nested", want: "example.com/a"},
+		{pwd: "a/b", want: "example.com/b"},
+		{pwd: "a/b/c", want: "example.com/b"},
+	}
+
+	for _, test := range tests {
+		pwd := filepath.Join(root, filepath.FromSlash(test.pwd))
+
+		m, err := modInfo(context.Background(), pwd)
+		if err != nil {
+			t.Errorf("modInfo(%s): %s", test.pwd, err)
+			continue
+		}
+
+		if m.Path != test.want {
+			t.Errorf("modInfo(%s).Path = %q, want %q", test.pwd, m.Path, test.want)
+		}
+	}
+
+	if _, err := modInfo(context.Background(), filepath.Join(root, "outside")); err == nil {
+		t.Error("modInfo(outside): no error")
+	}
+
+	// out of the workspace, the module of pwd is the only main module
+	t.Setenv("GOWORK", "off")
+
+	m, err := modInfo(context.Background(), filepath.Join(root, "a", "b", "c"))
+	if err != nil {
+		t.Fatalf("modInfo(a/b/c): %s", err)
+	}
+
+	if m.Path != "example.com/b" {
+		t.Errorf("modInfo(a/b/c).Path = %q, want %q", m.Path, "example.com/b")
+	}
+}
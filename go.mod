@@ -5,6 +5,10 @@ go 1.22.1
 require (
 	github.com/alecthomas/participle/v2 v2.1.1
 	github.com/samber/lo v1.39.0
+	golang.org/x/tools v0.21.0
 )
 
-require golang.org/x/exp v0.0.0-20240325151524-a685a6edb6d8 // indirect
+require (
+	golang.org/x/exp v0.0.0-20240325151524-a685a6edb6d8 // indirect
+	golang.org/x/mod v0.17.0 // indirect
+)
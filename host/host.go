@@ -0,0 +1,229 @@
+// Package host runs Go macros for craft. The programs craft generates are
+// macro hosts: they register the macros and declarations they are compiled
+// with and serve the requests of the craft protocol.
+package host
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"text/template"
+
+	"github.com/aria3ppp/craft/macro"
+	"github.com/aria3ppp/craft/protocol"
+	"github.com/aria3ppp/craft/typedesc"
+)
+
+// Registry holds what a host is compiled with.
+//
+// Macros are the macro functions by the import path of their package and
+// their name, as in "example.com/macros/json.Marshal". A macro function has
+// one of the signatures:
+//
+//	func(string | macro.Input, reflect.Type | macro.TypeData) (string, error)
+//
+// Declarations are the declarations macros may run on, by the name of the
+// declaration in requests.
+type Registry struct {
+	Macros       map[string]any
+	Declarations map[string]Declaration
+}
+
+// Declaration holds the reflection data of a declaration. TypeOf is nil for
+// a generic declaration and Consts are the values of the constants of the
+// type by name.
+type Declaration struct {
+	TypeOf reflect.Type
+	Consts map[string]reflect.Value
+}
+
+// Main serves the requests on the standard input and exits when it is
+// closed. Macros writing to the standard output write to the standard error
+// instead, so they do not corrupt the responses.
+func Main(registry Registry) {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+
+	if err := Serve(os.Stdin, stdout, registry); err != nil {
+		fmt.Fprintf(os.Stderr, "craft host: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// Serve answers the requests read from r with responses written to w until r
+// is exhausted.
+func Serve(r io.Reader, w io.Writer, registry Registry) error {
+	var (
+		decoder = protocol.NewDecoder(r)
+		encoder = protocol.NewEncoder(w)
+	)
+
+	for {
+		var request protocol.Request
+
+		if err := decoder.Decode(&request); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if err := encoder.Encode(registry.Handle(request)); err != nil {
+			return err
+		}
+	}
+}
+
+// Handle runs the macro of the request and returns the response. Errors and
+// panics of the macro are diagnostics of the response.
+func (r Registry) Handle(request protocol.Request) (response protocol.Response) {
+	if request.Version != protocol.Version {
+		return protocol.Errorf(request, "craft host speaks protocol version %d, got a request of version %d; regenerate the host", protocol.Version, request.Version)
+	}
+
+	declaration, exists := r.Declarations[request.Declaration.Name]
+	if !exists {
+		return protocol.Errorf(request, "[INTERNAL ERROR] [file a bug] declaration %s is not registered in the host", request.Declaration.Name)
+	}
+
+	if request.Describe {
+		return r.describe(request, declaration)
+	}
+
+	name := request.Package + "." + request.Macro
+
+	fn, exists := r.Macros[name]
+	if !exists {
+		return protocol.Errorf(request, "[INTERNAL ERROR] [file a bug] macro %s is not registered in the host", name)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			response = protocol.Errorf(request, "macro %s panicked on %s: %v", request.Macro, request.Declaration.Name, recovered)
+		}
+	}()
+
+	typeData, err := TypeData(request.Declaration, declaration)
+	if err != nil {
+		return protocol.Errorf(request, "[INTERNAL ERROR] [file a bug] failed to parse the type source: %s", err)
+	}
+
+	out, err := call(fn, request.Input, typeData)
+	if err != nil {
+		return protocol.Errorf(request, "macro %s failed on %s: %s", request.Macro, request.Declaration.Name, err)
+	}
+
+	content, err := execute(out, request.Declaration)
+	if err != nil {
+		return protocol.Errorf(request, "[INTERNAL ERROR] [file a bug] %s", err)
+	}
+
+	return protocol.Response{
+		Version: protocol.Version,
+		ID:      request.ID,
+		Files: []protocol.File{
+			{Path: request.Output, Content: content},
+		},
+	}
+}
+
+// describe answers a request for the descriptor of the declaration.
+func (r Registry) describe(request protocol.Request, declaration Declaration) protocol.Response {
+	typeData, err := TypeData(request.Declaration, declaration)
+	if err != nil {
+		return protocol.Errorf(request, "[INTERNAL ERROR] [file a bug] failed to parse the type source: %s", err)
+	}
+
+	return protocol.Response{
+		Version:    protocol.Version,
+		ID:         request.ID,
+		Descriptor: typeData.Descriptor,
+	}
+}
+
+// TypeData returns the data a macro taking a macro.TypeData gets for the
+// declaration of a request.
+func TypeData(requested protocol.Declaration, declaration Declaration) (macro.TypeData, error) {
+	typeData := macro.TypeData{
+		TypeOf: declaration.TypeOf,
+		Fields: requested.Fields,
+	}
+
+	for _, constant := range requested.Consts {
+		typeData.Consts = append(typeData.Consts, macro.Const{
+			Name:     constant.Name,
+			Value:    declaration.Consts[constant.Name],
+			Doc:      constant.Doc,
+			Comment:  constant.Comment,
+			Position: constant.Position,
+		})
+	}
+
+	if requested.Source != "" {
+		var err error
+
+		if requested.Func {
+			typeData.FileSet, typeData.Func, err = macro.ParseFuncDecl(requested.Filename, requested.Source)
+		} else {
+			typeData.FileSet, typeData.Source, err = macro.ParseTypeSpec(requested.Filename, requested.Source)
+		}
+
+		if err != nil {
+			return macro.TypeData{}, err
+		}
+	}
+
+	typeData.Descriptor = typedesc.Describe(requested.PkgPath, typeData.TypeOf, typeData.Source, typeData.Func)
+
+	return typeData, nil
+}
+
+func call(fn any, input macro.Input, typeData macro.TypeData) (string, error) {
+	switch fn := fn.(type) {
+	case func(string, reflect.Type) (string, error):
+		return fn(input.Raw, typeData.TypeOf)
+	case func(string, macro.TypeData) (string, error):
+		return fn(input.Raw, typeData)
+	case func(macro.Input, reflect.Type) (string, error):
+		return fn(input, typeData.TypeOf)
+	case func(macro.Input, macro.TypeData) (string, error):
+		return fn(input, typeData)
+	default:
+		return "", fmt.Errorf("[INTERNAL ERROR] [file a bug] unsupported macro signature %T", fn)
+	}
+}
+
+// execute executes the output of a macro as a template of the package and
+// type of the declaration.
+func execute(out string, declaration protocol.Declaration) (string, error) {
+	// add compile time checks
+
+	out = fmt.Sprintf(
+		"%s%s",
+		out,
+		"\n\nfunc _() { _ = \"compile time checks\" }",
+	)
+
+	tmplt, err := template.New("").Parse(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to template.New: %s", err)
+	}
+
+	bytesBuffer := bytes.NewBuffer(make([]byte, 0, len(out)))
+
+	values := map[string]any{
+		"Package": declaration.Package,
+		"Type": map[string]string{
+			"Name": declaration.TypeName,
+		},
+	}
+
+	if err = tmplt.Execute(bytesBuffer, values); err != nil {
+		return "", fmt.Errorf("failed to template.Execute: %s", err)
+	}
+
+	return bytesBuffer.String(), nil
+}
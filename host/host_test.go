@@ -0,0 +1,138 @@
+package host_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aria3ppp/craft/host"
+	"github.com/aria3ppp/craft/macro"
+	"github.com/aria3ppp/craft/protocol"
+)
+
+type User struct {
+	Name string
+}
+
+var registry = host.Registry{
+	Macros: map[string]any{
+		"example.com/m.Name": func(input string, t reflect.Type) (string, error) {
+			return "package {{.Package}}\n\n// " + t.Name() + " " + input + "\n", nil
+		},
+		"example.com/m.Args": func(input macro.Input, typeData macro.TypeData) (string, error) {
+			value, _ := input.Named("suffix")
+			return "package {{.Package}}\n\n// " + typeData.Source.Name.Name + value.String + "\n", nil
+		},
+		"example.com/m.Fail": func(input string, t reflect.Type) (string, error) {
+			return "", errors.New("boom")
+		},
+		"example.com/m.Panic": func(input string, t reflect.Type) (string, error) {
+			panic("oops")
+		},
+	},
+	Declarations: map[string]host.Declaration{
+		"User": {TypeOf: reflect.TypeOf(User{})},
+	},
+}
+
+func request(macroName string, input macro.Input) protocol.Request {
+	return protocol.Request{
+		Version: protocol.Version,
+		Package: "example.com/m",
+		Macro:   macroName,
+		Input:   input,
+		Declaration: protocol.Declaration{
+			Name:     "User",
+			TypeName: "User",
+			Package:  "models",
+			Filename: "user.go",
+			PkgPath:  reflect.TypeOf(User{}).PkgPath(),
+			Source:   "package models\n\ntype User struct {\n\tName string\n}",
+		},
+		Output: "User_m_" + macroName + ".crafted.go",
+	}
+}
+
+func TestServe(t *testing.T) {
+	var (
+		requests = []protocol.Request{
+			request("Name", macro.Input{Raw: "raw"}),
+			request("Args", macro.Input{Args: []macro.Arg{{Name: "suffix", Value: macro.Value{Kind: macro.KindString, String: "!"}}}}),
+			request("Fail", macro.Input{}),
+			request("Panic", macro.Input{}),
+			request("Missing", macro.Input{}),
+			{Version: protocol.Version, Declaration: protocol.Declaration{Name: "User", PkgPath: reflect.TypeOf(User{}).PkgPath()}, Describe: true},
+			{Version: protocol.Version - 1, Declaration: protocol.Declaration{Name: "User"}},
+			{Version: protocol.Version, Declaration: protocol.Declaration{Name: "Unknown"}},
+		}
+		in  bytes.Buffer
+		out bytes.Buffer
+	)
+
+	encoder := protocol.NewEncoder(&in)
+
+	for i, request := range requests {
+		request.ID = i
+
+		if err := encoder.Encode(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := host.Serve(&in, &out, registry); err != nil {
+		t.Fatalf("Serve: %s", err)
+	}
+
+	decoder := protocol.NewDecoder(&out)
+	responses := make([]protocol.Response, len(requests))
+
+	for i := range responses {
+		if err := decoder.Decode(&responses[i]); err != nil {
+			t.Fatalf("Decode response %d: %s", i, err)
+		}
+
+		if responses[i].ID != i || responses[i].Version != protocol.Version {
+			t.Errorf("response %d has ID %d and version %d", i, responses[i].ID, responses[i].Version)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		content    string
+		diagnostic string
+	}{
+		{name: "string input", content: "package models\n\n// User raw\n"},
+		{name: "macro.Input", content: "package models\n\n// User!\n"},
+		{name: "error", diagnostic: "macro Fail failed on User: boom"},
+		{name: "panic", diagnostic: "macro Panic panicked on User: oops"},
+		{name: "unregistered macro", diagnostic: "macro example.com/m.Missing is not registered"},
+		{name: "describe"},
+		{name: "version", diagnostic: "speaks protocol version"},
+		{name: "unregistered declaration", diagnostic: "declaration Unknown is not registered"},
+	}
+
+	for i, test := range tests {
+		response := responses[i]
+
+		switch {
+		case test.content != "":
+			if len(response.Files) != 1 || response.Files[0].Path != requests[i].Output || !strings.HasPrefix(response.Files[0].Content, test.content) {
+				t.Errorf("%s: files = %+v, want %s starting with %q", test.name, response.Files, requests[i].Output, test.content)
+			}
+		case test.diagnostic != "":
+			if len(response.Files) != 0 || len(response.Diagnostics) != 1 || !strings.Contains(response.Diagnostics[0].Message, test.diagnostic) {
+				t.Errorf("%s: diagnostics = %+v, want one containing %q", test.name, response.Diagnostics, test.diagnostic)
+			}
+		}
+
+		if len(test.diagnostic) == 0 && len(response.Diagnostics) > 0 {
+			t.Errorf("%s: unexpected diagnostics %+v", test.name, response.Diagnostics)
+		}
+	}
+
+	if descriptor := responses[5].Descriptor; descriptor == nil || descriptor.Type.Name != "User" || len(descriptor.Type.Fields) != 1 {
+		t.Errorf("describe: descriptor = %+v, want the descriptor of User", descriptor)
+	}
+}
@@ -0,0 +1,105 @@
+// Package macro defines the values craft passes to macros.
+package macro
+
+import "fmt"
+
+type Kind uint8
+
+const (
+	KindString Kind = iota + 1
+	KindInt
+	KindFloat
+	KindBool
+	KindIdent
+	KindList
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindBool:
+		return "bool"
+	case KindIdent:
+		return "identifier"
+	case KindList:
+		return "list"
+	default:
+		return "invalid"
+	}
+}
+
+// MarshalText encodes the kind as its name, so it reads well in the JSON
+// messages of the craft protocol.
+func (k Kind) MarshalText() ([]byte, error) {
+	if k.String() == "invalid" {
+		return nil, fmt.Errorf("macro: invalid kind %d", k)
+	}
+
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes a kind encoded by MarshalText.
+func (k *Kind) UnmarshalText(text []byte) error {
+	for kind := KindString; kind <= KindList; kind++ {
+		if kind.String() == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+
+	return fmt.Errorf("macro: invalid kind %q", text)
+}
+
+// Input is the input of a macro invocation. It is either a raw string, as in
+// #pkg.Macro(`raw`), or a list of positional and named arguments, as in
+// #pkg.Macro("positional", name: "value").
+type Input struct {
+	Raw  string
+	Args []Arg
+}
+
+// Arg is a macro argument. Name is empty for positional arguments.
+type Arg struct {
+	Name  string
+	Value Value
+}
+
+// Value is a macro argument value. Only the field matching Kind is set.
+type Value struct {
+	Kind   Kind
+	String string
+	Int    int64
+	Float  float64
+	Bool   bool
+	Ident  string
+	List   []Value
+}
+
+// Positional returns the positional arguments in order.
+func (in Input) Positional() []Value {
+	var values []Value
+
+	for _, arg := range in.Args {
+		if arg.Name == "" {
+			values = append(values, arg.Value)
+		}
+	}
+
+	return values
+}
+
+// Named returns the value of the named argument and whether it was given.
+func (in Input) Named(name string) (Value, bool) {
+	for _, arg := range in.Args {
+		if arg.Name != "" && arg.Name == name {
+			return arg.Value, true
+		}
+	}
+
+	return Value{}, false
+}
@@ -0,0 +1,212 @@
+package macro
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+
+	"github.com/aria3ppp/craft/typedesc"
+)
+
+// TypeData describes the type a macro is invoked on both as reflection data
+// and as source code.
+//
+// Source is nil if craft could not find the type declaration. Positions in
+// Source are positions in the original file and resolve through FileSet.
+//
+// A macro on a function or method gets the function type, with the receiver
+// as the first parameter of a method, as TypeOf and the declaration as Func.
+//
+// Fields are the fields of a struct type with macros on them and Consts are
+// the constants of the type declared in its package, e.g. the members of an
+// enum declared with iota.
+//
+// Descriptor describes the declaration as a serializable value built from
+// both TypeOf and the source.
+type TypeData struct {
+	TypeOf     reflect.Type
+	Source     *ast.TypeSpec
+	Func       *ast.FuncDecl
+	FileSet    *token.FileSet
+	Fields     []Field
+	Consts     []Const
+	Descriptor *typedesc.Descriptor
+}
+
+// Const is a constant of the type, with its value evaluated by the generated
+// program. Doc and Comment are the text of its doc and line comments.
+type Const struct {
+	Name     string
+	Value    reflect.Value
+	Doc      string
+	Comment  string
+	Position token.Position
+}
+
+// Field is a struct field with macros on it, as in:
+//
+//	type T struct {
+//		// #validate.Range(min: 1, max: 10)
+//		N int
+//	}
+//
+// Index is the index of the field in the struct type, as used by
+// reflect.Type.Field, and Name is its name or the type name of an embedded
+// field.
+type Field struct {
+	Name   string
+	Index  int
+	Macros []FieldMacro
+}
+
+// FieldMacro is a macro invocation on a struct field. Field macros are not
+// run; they are passed to the macros of the struct type as metadata, so
+// their package need not be a macro package.
+type FieldMacro struct {
+	Package  string
+	Macro    string
+	Input    Input
+	Position token.Position
+}
+
+// ParseTypeSpec parses the type declaration source craft serializes into the
+// generated program. The doc comment of the declaration is always set on the
+// returned spec, whether or not the declaration is grouped.
+func ParseTypeSpec(filename string, src string) (*token.FileSet, *ast.TypeSpec, error) {
+	fileSet := token.NewFileSet()
+
+	file, err := parser.ParseFile(fileSet, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+
+			if typeSpec.Doc == nil && !genDecl.Lparen.IsValid() {
+				typeSpec.Doc = genDecl.Doc
+			}
+
+			return fileSet, typeSpec, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%s: no type declaration in source", filename)
+}
+
+// ParseFuncDecl parses the function declaration source craft serializes into
+// the generated program.
+func ParseFuncDecl(filename string, src string) (*token.FileSet, *ast.FuncDecl, error) {
+	fileSet := token.NewFileSet()
+
+	file, err := parser.ParseFile(fileSet, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+			return fileSet, funcDecl, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%s: no function declaration in source", filename)
+}
+
+// TypeParam is a type parameter of a generic type.
+type TypeParam struct {
+	Name       string
+	Constraint ast.Expr
+}
+
+// TypeParams returns the type parameters of a generic type or function in
+// order, or nil if it is not generic. TypeOf is nil for a generic type unless
+// the macro is on a variable instantiating it.
+//
+// The type parameters of a method are those of its receiver type, named as
+// in the receiver. Their constraints are declared by the type, so Constraint
+// is nil.
+func (d TypeData) TypeParams() []TypeParam {
+	var typeParams *ast.FieldList
+
+	switch {
+	case d.Source != nil:
+		typeParams = d.Source.TypeParams
+	case d.Func != nil && d.Func.Recv != nil:
+		var params []TypeParam
+
+		for _, param := range typedesc.ReceiverTypeParams(d.Func.Recv) {
+			params = append(params, TypeParam{Name: param.Name})
+		}
+
+		return params
+	case d.Func != nil:
+		typeParams = d.Func.Type.TypeParams
+	}
+
+	if typeParams == nil {
+		return nil
+	}
+
+	var params []TypeParam
+
+	for _, field := range typeParams.List {
+		for _, name := range field.Names {
+			params = append(params, TypeParam{
+				Name:       name.Name,
+				Constraint: field.Type,
+			})
+		}
+	}
+
+	return params
+}
+
+// Method is a method declared by an interface type.
+type Method struct {
+	Name string
+	Type *ast.FuncType
+	Doc  *ast.CommentGroup
+}
+
+// InterfaceMethods returns the methods an interface type declares, with
+// their parameter names, in source order. Methods of embedded interfaces are
+// not included, TypeOf lists them all. It returns nil if the type is not an
+// interface.
+func (d TypeData) InterfaceMethods() []Method {
+	if d.Source == nil {
+		return nil
+	}
+
+	interfaceType, ok := d.Source.Type.(*ast.InterfaceType)
+	if !ok {
+		return nil
+	}
+
+	var methods []Method
+
+	for _, field := range interfaceType.Methods.List {
+		funcType, ok := field.Type.(*ast.FuncType)
+		if !ok {
+			continue
+		}
+
+		for _, name := range field.Names {
+			methods = append(methods, Method{
+				Name: name.Name,
+				Type: funcType,
+				Doc:  field.Doc,
+			})
+		}
+	}
+
+	return methods
+}
@@ -2,16 +2,41 @@ package parser
 
 import (
 	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
 )
 
 type MacroAST struct {
 	Package string `parser:" '#' @Ident"`
 	Macro   string `parser:" '.' @Ident"`
-	Input   string `parser:"( '(' @RawString ')' )?"`
+	Input   string `parser:"( '(' ( @RawString ')'"`
+	Args    []*Arg `parser:"      | ( @@ ( ',' @@ )* ','? )? ')' ) )?"`
+}
+
+type Arg struct {
+	Pos   lexer.Position
+	Name  string `parser:"( @Ident ':' )?"`
+	Value *Value `parser:"@@"`
+}
+
+// Value is an argument value. Numbers are kept as their literal text so the
+// caller can report range errors at the position of the value.
+type Value struct {
+	Pos    lexer.Position
+	String *string `parser:"  @(String | RawString)"`
+	Float  *string `parser:"| @('-'? Float)"`
+	Int    *string `parser:"| @('-'? Int)"`
+	Bool   *string `parser:"| @('true' | 'false')"`
+	Ident  *string `parser:"| @Ident"`
+	List   *List   `parser:"| @@"`
+}
+
+type List struct {
+	Values []*Value `parser:"'[' ( @@ ( ',' @@ )* ','? )? ']'"`
 }
 
 func NewMacroASTParser() (*participle.Parser[MacroAST], error) {
 	return participle.Build[MacroAST](
-		participle.Unquote("RawString"),
+		participle.Unquote("String", "RawString"),
+		participle.UseLookahead(2),
 	)
 }
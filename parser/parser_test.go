@@ -0,0 +1,154 @@
+package parser_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aria3ppp/craft/parser"
+)
+
+func TestParseMacro(t *testing.T) {
+	tests := []struct {
+		text    string
+		pkg     string
+		macro   string
+		input   string
+		args    []string
+		wantErr bool
+	}{
+		{text: "#pkg.Macro", pkg: "pkg", macro: "Macro"},
+		{text: "#pkg.Macro()", pkg: "pkg", macro: "Macro"},
+		{text: "#pkg.Macro(`raw input`)", pkg: "pkg", macro: "Macro", input: "raw input"},
+		{text: "#pkg.Macro(`multi\nline`)", pkg: "pkg", macro: "Macro", input: "multi\nline"},
+		{
+			text:  `#pkg.Macro("a", 1, -2, 3.5, -0.5, true, false, Ident)`,
+			pkg:   "pkg",
+			macro: "Macro",
+			args:  []string{`string("a")`, "int(1)", "int(-2)", "float(3.5)", "float(-0.5)", "bool(true)", "bool(false)", "ident(Ident)"},
+		},
+		{
+			text:  `#pkg.Macro(name: "value", min: 1, raw: ` + "`x`" + `)`,
+			pkg:   "pkg",
+			macro: "Macro",
+			args:  []string{`name=string("value")`, "min=int(1)", `raw=string("x")`},
+		},
+		{
+			text:  `#pkg.Macro("positional", named: 0x10)`,
+			pkg:   "pkg",
+			macro: "Macro",
+			args:  []string{`string("positional")`, "named=int(0x10)"},
+		},
+		{
+			text:  `#pkg.Macro(list: [1, 2, 3,], empty: [], nested: [["a"], ["b"]],)`,
+			pkg:   "pkg",
+			macro: "Macro",
+			args:  []string{"list=[int(1) int(2) int(3)]", "empty=[]", `nested=[[string("a")] [string("b")]]`},
+		},
+		{
+			// a raw string followed by arguments is a positional argument
+			text:  "#pkg.Macro(`raw`, 1)",
+			pkg:   "pkg",
+			macro: "Macro",
+			args:  []string{`string("raw")`, "int(1)"},
+		},
+		{text: "pkg.Macro", wantErr: true},
+		{text: "#pkg", wantErr: true},
+		{text: "#pkg.Macro(", wantErr: true},
+		{text: "#pkg.Macro(a: )", wantErr: true},
+		{text: "#pkg.Macro(1 2)", wantErr: true},
+		{text: "#pkg.Macro([1, 2)", wantErr: true},
+	}
+
+	p, err := parser.NewMacroASTParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			macroAST, err := p.ParseString("", test.text)
+
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ParseString succeeded, want an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseString: %s", err)
+			}
+
+			if macroAST.Package != test.pkg || macroAST.Macro != test.macro || macroAST.Input != test.input {
+				t.Errorf("ParseString = %q.%q(%q), want %q.%q(%q)", macroAST.Package, macroAST.Macro, macroAST.Input, test.pkg, test.macro, test.input)
+			}
+
+			var args []string
+
+			for _, arg := range macroAST.Args {
+				args = append(args, formatArg(arg))
+			}
+
+			if !reflect.DeepEqual(args, test.args) {
+				t.Errorf("args = %q, want %q", args, test.args)
+			}
+		})
+	}
+}
+
+func TestParseArgPosition(t *testing.T) {
+	p, err := parser.NewMacroASTParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := `#pkg.Macro(a: 1, b: [2, 3])`
+
+	macroAST, err := p.ParseString("", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if offset := macroAST.Args[1].Pos.Offset; offset != strings.Index(text, "b:") {
+		t.Errorf("offset of b = %d, want %d", offset, strings.Index(text, "b:"))
+	}
+
+	if offset := macroAST.Args[1].Value.List.Values[1].Pos.Offset; offset != strings.Index(text, "3") {
+		t.Errorf("offset of 3 = %d, want %d", offset, strings.Index(text, "3"))
+	}
+}
+
+func formatArg(arg *parser.Arg) string {
+	if arg.Name != "" {
+		return arg.Name + "=" + formatValue(arg.Value)
+	}
+
+	return formatValue(arg.Value)
+}
+
+func formatValue(value *parser.Value) string {
+	switch {
+	case value.String != nil:
+		return fmt.Sprintf("string(%q)", *value.String)
+	case value.Int != nil:
+		return fmt.Sprintf("int(%s)", *value.Int)
+	case value.Float != nil:
+		return fmt.Sprintf("float(%s)", *value.Float)
+	case value.Bool != nil:
+		return fmt.Sprintf("bool(%s)", *value.Bool)
+	case value.Ident != nil:
+		return fmt.Sprintf("ident(%s)", *value.Ident)
+	case value.List != nil:
+		elems := make([]string, 0, len(value.List.Values))
+
+		for _, elem := range value.List.Values {
+			elems = append(elems, formatValue(elem))
+		}
+
+		return "[" + strings.Join(elems, " ") + "]"
+	default:
+		return "invalid"
+	}
+}
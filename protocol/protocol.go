@@ -0,0 +1,179 @@
+// Package protocol defines the messages craft exchanges with macro hosts.
+//
+// A macro host is a process craft starts once and sends requests to, one JSON
+// object per line on its standard input. The host answers every request with
+// a response, one JSON object per line on its standard output, in the order
+// of the requests. The host exits when its standard input is closed.
+//
+// Every message carries the Version of the protocol it speaks; a host answers
+// a request of another version with a diagnostic and no files.
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+
+	"github.com/aria3ppp/craft/macro"
+	"github.com/aria3ppp/craft/typedesc"
+)
+
+// Version is the version of the protocol. It changes whenever a message
+// changes in a way a host or craft written against the previous version
+// would misread.
+const Version = 2
+
+// Request asks a host to run the macro named Macro of the macro package
+// imported as Package with the Input on the Declaration. For an external
+// macro Package is the path of its executable, as given to craft.
+//
+// A request with Describe set asks the host for the Descriptor of the
+// Declaration instead, which craft sends to external macros as they have no
+// reflection of their own.
+type Request struct {
+	Version     int
+	ID          int
+	Package     string
+	Macro       string
+	Input       macro.Input
+	Declaration Declaration
+	Descriptor  *typedesc.Descriptor `json:",omitempty"`
+	Describe    bool                 `json:",omitempty"`
+	// Output is the path of the file craft expects the macro to generate.
+	Output string
+}
+
+// Declaration is the declaration a macro is invoked on.
+//
+// Name identifies the declaration in the host: the name of a type, variable
+// or function, or Receiver.Method for a method. TypeName is the name of the
+// type the generated code is about, i.e. the type of a variable or the
+// receiver of a method.
+//
+// Package is the name of the package of the declaration and PkgPath its
+// path as reflection reports it. Source is the source of the declaration,
+// padded so positions in it are positions in Filename.
+type Declaration struct {
+	Name     string
+	TypeName string
+	Package  string
+	PkgPath  string
+	Filename string
+	Source   string
+	Func     bool
+	Generic  bool
+	Fields   []macro.Field
+	Consts   []Const
+}
+
+// Const is a constant of the type of the declaration. Its value is only
+// known to the host.
+type Const struct {
+	Name     string
+	Doc      string
+	Comment  string
+	Position token.Position
+}
+
+// Response answers the request with the same ID. Descriptor answers a
+// request with Describe set.
+type Response struct {
+	Version     int
+	ID          int
+	Files       []File
+	Diagnostics []Diagnostic
+	Descriptor  *typedesc.Descriptor `json:",omitempty"`
+}
+
+// File is a file generated by a macro. A relative Path is relative to the
+// directory of the file the macro is invoked in.
+type File struct {
+	Path    string
+	Content string
+}
+
+// Diagnostic is an error of a macro. Position is the position in the source
+// file the diagnostic is about, or nil for the position of the macro
+// invocation.
+type Diagnostic struct {
+	Message  string
+	Position *token.Position `json:",omitempty"`
+}
+
+// Errorf returns a response to the request with a single diagnostic at the
+// position of the macro invocation.
+func Errorf(request Request, format string, args ...any) Response {
+	return Response{
+		Version: Version,
+		ID:      request.ID,
+		Diagnostics: []Diagnostic{
+			{Message: fmt.Sprintf(format, args...)},
+		},
+	}
+}
+
+// Decoder reads messages from a JSON-lines stream.
+type Decoder struct {
+	scanner *bufio.Scanner
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	scanner := bufio.NewScanner(r)
+	// declarations and generated files easily exceed the default limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+
+	return &Decoder{scanner: scanner}
+}
+
+// Decode reads the next message into v. It returns io.EOF at the end of the
+// stream and skips empty lines.
+func (d *Decoder) Decode(v any) error {
+	for d.scanner.Scan() {
+		line := d.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(line, v); err != nil {
+			return fmt.Errorf("protocol: failed to decode %q: %w", line, err)
+		}
+
+		return nil
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+// Encoder writes messages to a JSON-lines stream.
+type Encoder struct {
+	writer *bufio.Writer
+}
+
+// NewEncoder returns an encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{writer: bufio.NewWriter(w)}
+}
+
+// Encode writes the message as a line and flushes it, so the other end can
+// answer before the stream is closed.
+func (e *Encoder) Encode(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	if _, err := e.writer.Write(line); err != nil {
+		return err
+	}
+
+	return e.writer.Flush()
+}
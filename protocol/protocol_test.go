@@ -0,0 +1,137 @@
+package protocol_test
+
+import (
+	"bytes"
+	"errors"
+	"go/token"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aria3ppp/craft/macro"
+	"github.com/aria3ppp/craft/protocol"
+)
+
+func TestRoundTrip(t *testing.T) {
+	request := protocol.Request{
+		Version: protocol.Version,
+		ID:      3,
+		Package: "example.com/macros",
+		Macro:   "Marshal",
+		Input: macro.Input{Args: []macro.Arg{
+			{Value: macro.Value{Kind: macro.KindString, String: "line\nbreak"}},
+			{Name: "tags", Value: macro.Value{Kind: macro.KindList, List: []macro.Value{
+				{Kind: macro.KindIdent, Ident: "json"},
+			}}},
+		}},
+		Declaration: protocol.Declaration{
+			Name:     "User",
+			TypeName: "User",
+			Package:  "models",
+			PkgPath:  "example.com/models",
+			Filename: "models/user.go",
+			Source:   "type User struct {\n\tName string\n}",
+			Consts: []protocol.Const{
+				{Name: "Admin", Position: token.Position{Filename: "models/user.go", Line: 7, Column: 2}},
+			},
+		},
+		Output: "User_m_Marshal.crafted.go",
+	}
+
+	response := protocol.Response{
+		Version: protocol.Version,
+		ID:      3,
+		Files:   []protocol.File{{Path: "User_m_Marshal.crafted.go", Content: "package models\n"}},
+		Diagnostics: []protocol.Diagnostic{
+			{Message: "at the macro"},
+			{Message: "at a field", Position: &token.Position{Filename: "models/user.go", Line: 2, Column: 2}},
+		},
+	}
+
+	var stream bytes.Buffer
+
+	encoder := protocol.NewEncoder(&stream)
+
+	for _, message := range []any{request, response} {
+		if err := encoder.Encode(message); err != nil {
+			t.Fatalf("Encode: %s", err)
+		}
+	}
+
+	// every message is a single line, whatever its strings hold
+	if lines := strings.Count(stream.String(), "\n"); lines != 2 {
+		t.Fatalf("encoded 2 messages on %d lines:\n%s", lines, stream.String())
+	}
+
+	// kinds are encoded by name
+	if !strings.Contains(stream.String(), `"Kind":"list"`) {
+		t.Errorf("kinds not encoded by name:\n%s", stream.String())
+	}
+
+	var (
+		decoder         = protocol.NewDecoder(&stream)
+		decodedRequest  protocol.Request
+		decodedResponse protocol.Response
+	)
+
+	if err := decoder.Decode(&decodedRequest); err != nil {
+		t.Fatalf("Decode request: %s", err)
+	}
+
+	if err := decoder.Decode(&decodedResponse); err != nil {
+		t.Fatalf("Decode response: %s", err)
+	}
+
+	if !reflect.DeepEqual(decodedRequest, request) {
+		t.Errorf("request = %+v, want %+v", decodedRequest, request)
+	}
+
+	if !reflect.DeepEqual(decodedResponse, response) {
+		t.Errorf("response = %+v, want %+v", decodedResponse, response)
+	}
+
+	if err := decoder.Decode(&decodedResponse); !errors.Is(err, io.EOF) {
+		t.Errorf("Decode at the end of the stream = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	// a message larger than the default buffer of a bufio.Scanner
+	large := strings.Repeat("x", 1<<20)
+
+	stream := "\n" + `{"ID":1,"Files":[{"Path":"a","Content":"` + large + `"}]}` + "\n\n" + `{"ID":2}` + "\n" + "not json\n"
+	decoder := protocol.NewDecoder(strings.NewReader(stream))
+
+	for _, id := range []int{1, 2} {
+		var response protocol.Response
+
+		if err := decoder.Decode(&response); err != nil {
+			t.Fatalf("Decode: %s", err)
+		}
+
+		if response.ID != id {
+			t.Errorf("ID = %d, want %d", response.ID, id)
+		}
+	}
+
+	var response protocol.Response
+
+	if err := decoder.Decode(&response); err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("Decode of invalid JSON = %v, want an error", err)
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	response := protocol.Errorf(protocol.Request{ID: 4}, "macro %s failed", "M")
+
+	want := protocol.Response{
+		Version:     protocol.Version,
+		ID:          4,
+		Diagnostics: []protocol.Diagnostic{{Message: "macro M failed"}},
+	}
+
+	if !reflect.DeepEqual(response, want) {
+		t.Errorf("Errorf = %+v, want %+v", response, want)
+	}
+}
@@ -0,0 +1,424 @@
+package typedesc
+
+import (
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strconv"
+)
+
+// Describe returns the descriptor of a declaration of the package with the
+// import path pkgPath. typ is the reflect.Type of the declaration, nil for a
+// generic declaration, and spec or fn is its source, nil if unknown. The
+// type of a method declaration has the receiver as its first parameter.
+func Describe(pkgPath string, typ reflect.Type, spec *ast.TypeSpec, fn *ast.FuncDecl) *Descriptor {
+	d := &describer{
+		pkgPath: pkgPath,
+		named:   make(map[string]*Type),
+	}
+
+	descriptor := &Descriptor{Version: Version}
+
+	switch {
+	case typ != nil:
+		if isNamed(typ) {
+			d.rootID = typeID(typ)
+		}
+
+		descriptor.Type = d.define(typ)
+	case spec != nil:
+		descriptor.Type = describeSpec(spec)
+		descriptor.Type.ID = pkgPath + "." + spec.Name.Name
+		descriptor.Type.PkgPath = pkgPath
+	case fn != nil:
+		descriptor.Type = &Type{Kind: reflect.Func.String()}
+	default:
+		return descriptor
+	}
+
+	switch {
+	case spec != nil:
+		annotateSpec(descriptor.Type, spec)
+	case fn != nil:
+		descriptor.Type.Doc = fn.Doc.Text()
+		descriptor.Type.TypeParams = typeParams(fn.Type.TypeParams)
+
+		if fn.Recv != nil {
+			descriptor.Type.TypeParams = ReceiverTypeParams(fn.Recv)
+		}
+
+		var params []*ast.Field
+
+		if fn.Recv != nil {
+			params = append(params, fn.Recv.List...)
+		}
+
+		annotateFunc(descriptor.Type, append(params, fn.Type.Params.List...), fn.Type.Results)
+	}
+
+	if len(d.named) > 0 {
+		descriptor.Named = d.named
+	}
+
+	return descriptor
+}
+
+type describer struct {
+	pkgPath string
+	rootID  string
+	named   map[string]*Type
+}
+
+// describe returns the description of the type, or a reference to it if it
+// is a named type.
+func (d *describer) describe(t reflect.Type) *Type {
+	if !isNamed(t) {
+		return d.define(t)
+	}
+
+	id := typeID(t)
+
+	if _, exists := d.named[id]; !exists && id != d.rootID {
+		// the entry is set before the type is defined so references to it
+		// from its own definition end here
+		d.named[id] = &Type{}
+		*d.named[id] = *d.define(t)
+	}
+
+	return &Type{
+		Kind:    t.Kind().String(),
+		Ref:     id,
+		Name:    t.Name(),
+		PkgPath: t.PkgPath(),
+	}
+}
+
+// define returns the definition of the type. Named types of other packages
+// are only defined by name.
+func (d *describer) define(t reflect.Type) *Type {
+	desc := &Type{
+		Kind:    t.Kind().String(),
+		Name:    t.Name(),
+		PkgPath: t.PkgPath(),
+		String:  t.String(),
+	}
+
+	if isNamed(t) {
+		desc.ID = typeID(t)
+
+		if t.PkgPath() != d.pkgPath {
+			return desc
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Array:
+		desc.Elem = d.describe(t.Elem())
+		desc.Len = t.Len()
+	case reflect.Chan:
+		desc.Elem = d.describe(t.Elem())
+		desc.ChanDir = t.ChanDir().String()
+	case reflect.Map:
+		desc.Key = d.describe(t.Key())
+		desc.Elem = d.describe(t.Elem())
+	case reflect.Pointer, reflect.Slice:
+		desc.Elem = d.describe(t.Elem())
+	case reflect.Func:
+		d.defineFunc(desc, t, 0)
+	case reflect.Struct:
+		for i := range t.NumField() {
+			field := t.Field(i)
+
+			desc.Fields = append(desc.Fields, Field{
+				Name:     field.Name,
+				Type:     d.describe(field.Type),
+				Tag:      string(field.Tag),
+				Embedded: field.Anonymous,
+				Exported: field.IsExported(),
+			})
+		}
+	case reflect.Interface:
+		for i := range t.NumMethod() {
+			method := t.Method(i)
+
+			desc.Methods = append(desc.Methods, Method{
+				Name: method.Name,
+				Type: d.describeFunc(method.Type, 0),
+			})
+		}
+
+		return desc
+	}
+
+	if isNamed(t) {
+		// the method set of the pointer holds the methods of both receivers
+		pointer := reflect.PointerTo(t)
+
+		for i := range pointer.NumMethod() {
+			method := pointer.Method(i)
+			_, valueReceiver := t.MethodByName(method.Name)
+
+			desc.Methods = append(desc.Methods, Method{
+				Name:            method.Name,
+				Type:            d.describeFunc(method.Type, 1),
+				PointerReceiver: !valueReceiver,
+			})
+		}
+	}
+
+	return desc
+}
+
+// describeFunc returns the description of the function type without its
+// first skip parameters.
+func (d *describer) describeFunc(t reflect.Type, skip int) *Type {
+	desc := &Type{Kind: reflect.Func.String()}
+
+	d.defineFunc(desc, t, skip)
+
+	return desc
+}
+
+func (d *describer) defineFunc(desc *Type, t reflect.Type, skip int) {
+	for i := skip; i < t.NumIn(); i++ {
+		desc.Params = append(desc.Params, d.describe(t.In(i)))
+	}
+
+	for i := range t.NumOut() {
+		desc.Results = append(desc.Results, d.describe(t.Out(i)))
+	}
+
+	desc.Variadic = t.IsVariadic()
+}
+
+// describeSpec returns the description of a generic type declaration, which
+// has no reflect.Type, from its source.
+func describeSpec(spec *ast.TypeSpec) *Type {
+	desc := &Type{Name: spec.Name.Name}
+
+	switch typ := spec.Type.(type) {
+	case *ast.StructType:
+		desc.Kind = reflect.Struct.String()
+
+		for _, field := range typ.Fields.List {
+			var tag string
+
+			if field.Tag != nil {
+				tag, _ = strconv.Unquote(field.Tag.Value)
+			}
+
+			names := fieldNames(field)
+
+			for _, name := range names {
+				desc.Fields = append(desc.Fields, Field{
+					Name:     name,
+					Type:     &Type{String: types.ExprString(field.Type)},
+					Tag:      tag,
+					Embedded: len(field.Names) == 0,
+					Exported: ast.IsExported(name),
+				})
+			}
+		}
+	case *ast.InterfaceType:
+		desc.Kind = reflect.Interface.String()
+
+		for _, field := range typ.Methods.List {
+			if _, ok := field.Type.(*ast.FuncType); !ok {
+				continue
+			}
+
+			for _, name := range field.Names {
+				desc.Methods = append(desc.Methods, Method{
+					Name: name.Name,
+					Type: &Type{Kind: reflect.Func.String(), String: types.ExprString(field.Type)},
+				})
+			}
+		}
+	default:
+		desc.String = types.ExprString(spec.Type)
+	}
+
+	return desc
+}
+
+// annotateSpec sets what the source of a type declaration tells and its
+// reflect.Type does not on the description of the type.
+func annotateSpec(desc *Type, spec *ast.TypeSpec) {
+	desc.Doc = spec.Doc.Text()
+	desc.TypeParams = typeParams(spec.TypeParams)
+
+	switch typ := spec.Type.(type) {
+	case *ast.StructType:
+		fields := make(map[string]*ast.Field)
+
+		for _, field := range typ.Fields.List {
+			for _, name := range fieldNames(field) {
+				fields[name] = field
+			}
+		}
+
+		for i := range desc.Fields {
+			if field, exists := fields[desc.Fields[i].Name]; exists {
+				desc.Fields[i].Doc = field.Doc.Text()
+				desc.Fields[i].Comment = field.Comment.Text()
+			}
+		}
+	case *ast.InterfaceType:
+		methods := make(map[string]*ast.Field)
+
+		for _, field := range typ.Methods.List {
+			for _, name := range field.Names {
+				methods[name.Name] = field
+			}
+		}
+
+		for i := range desc.Methods {
+			field, exists := methods[desc.Methods[i].Name]
+			if !exists {
+				continue
+			}
+
+			desc.Methods[i].Doc = field.Doc.Text()
+
+			if funcType, ok := field.Type.(*ast.FuncType); ok && desc.Methods[i].Type != nil {
+				annotateFunc(desc.Methods[i].Type, funcType.Params.List, funcType.Results)
+			}
+		}
+	}
+}
+
+// annotateFunc sets the names of the parameters and results of a function
+// from its source.
+func annotateFunc(desc *Type, params []*ast.Field, results *ast.FieldList) {
+	desc.ParamNames = fieldListNames(params)
+
+	if results != nil {
+		desc.ResultNames = fieldListNames(results.List)
+	}
+}
+
+// fieldListNames returns the names of the fields of a parameter list, one
+// per parameter, or nil if the parameters are unnamed.
+func fieldListNames(fields []*ast.Field) []string {
+	var (
+		names []string
+		named bool
+	)
+
+	for _, field := range fields {
+		if len(field.Names) == 0 {
+			names = append(names, "")
+			continue
+		}
+
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+			named = true
+		}
+	}
+
+	if !named {
+		return nil
+	}
+
+	return names
+}
+
+func typeParams(fields *ast.FieldList) []TypeParam {
+	if fields == nil {
+		return nil
+	}
+
+	var params []TypeParam
+
+	for _, field := range fields.List {
+		for _, name := range field.Names {
+			params = append(params, TypeParam{
+				Name:       name.Name,
+				Constraint: types.ExprString(field.Type),
+			})
+		}
+	}
+
+	return params
+}
+
+// ReceiverTypeParams returns the type parameters of the receiver type of a
+// method, named as in the receiver, as in func (s *Stack[T]) Push(v T), or
+// nil if the receiver type is not generic. Their constraints are declared by
+// the type, so they are left empty.
+func ReceiverTypeParams(recv *ast.FieldList) []TypeParam {
+	if recv == nil || len(recv.List) != 1 {
+		return nil
+	}
+
+	recvType := recv.List[0].Type
+
+	if star, ok := recvType.(*ast.StarExpr); ok {
+		recvType = star.X
+	}
+
+	var indices []ast.Expr
+
+	switch typ := recvType.(type) {
+	case *ast.IndexExpr:
+		indices = []ast.Expr{typ.Index}
+	case *ast.IndexListExpr:
+		indices = typ.Indices
+	}
+
+	var params []TypeParam
+
+	for _, index := range indices {
+		if ident, ok := index.(*ast.Ident); ok {
+			params = append(params, TypeParam{Name: ident.Name})
+		}
+	}
+
+	return params
+}
+
+// fieldNames returns the names of a struct field, which is the type name of
+// an embedded field.
+func fieldNames(field *ast.Field) []string {
+	if len(field.Names) == 0 {
+		return []string{EmbeddedName(field.Type)}
+	}
+
+	names := make([]string, 0, len(field.Names))
+
+	for _, name := range field.Names {
+		names = append(names, name.Name)
+	}
+
+	return names
+}
+
+// EmbeddedName returns the name of an embedded field of the type, which is
+// the name of the type without its package, pointer or type arguments.
+func EmbeddedName(expr ast.Expr) string {
+	switch typ := expr.(type) {
+	case *ast.StarExpr:
+		return EmbeddedName(typ.X)
+	case *ast.SelectorExpr:
+		return typ.Sel.Name
+	case *ast.IndexExpr:
+		return EmbeddedName(typ.X)
+	case *ast.IndexListExpr:
+		return EmbeddedName(typ.X)
+	case *ast.Ident:
+		return typ.Name
+	}
+
+	return ""
+}
+
+// isNamed reports whether the type is a named type other than a predeclared
+// one, which are described inline.
+func isNamed(t reflect.Type) bool {
+	return t.Name() != "" && t.PkgPath() != ""
+}
+
+func typeID(t reflect.Type) string {
+	return t.PkgPath() + "." + t.Name()
+}
@@ -0,0 +1,162 @@
+package typedesc_test
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aria3ppp/craft/typedesc"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// pkgPath is the path of the package of the fixtures as reflection reports
+// it.
+var pkgPath = reflect.TypeOf(User{}).PkgPath()
+
+func TestDescribe(t *testing.T) {
+	specs, funcs := parseFixtures(t)
+
+	tests := []struct {
+		name string
+		typ  reflect.Type
+		spec *ast.TypeSpec
+		fn   *ast.FuncDecl
+	}{
+		{name: "struct", typ: reflect.TypeOf(User{}), spec: specs["User"]},
+		{name: "recursive", typ: reflect.TypeOf(Node{}), spec: specs["Node"]},
+		{name: "generic", spec: specs["Pair"]},
+		{name: "instantiation", typ: reflect.TypeOf(Pair[string, int]{}), spec: specs["Pair"]},
+		{name: "methods", typ: reflect.TypeOf(Counter(0)), spec: specs["Counter"]},
+		{name: "interface", typ: reflect.TypeOf((*Store)(nil)).Elem(), spec: specs["Store"]},
+		{name: "pointer_method", typ: reflect.TypeOf((*Counter).Inc), fn: funcs["Counter.Inc"]},
+		{name: "value_method", typ: reflect.TypeOf(Counter.Value), fn: funcs["Counter.Value"]},
+		{name: "generic_method", fn: funcs["Stack.Push"]},
+		{name: "func", typ: reflect.TypeOf(Sum), fn: funcs["Sum"]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			descriptor := typedesc.Describe(pkgPath, test.typ, test.spec, test.fn)
+
+			var encoded bytes.Buffer
+
+			if err := typedesc.Encode(&encoded, descriptor); err != nil {
+				t.Fatalf("Encode: %s", err)
+			}
+
+			golden := filepath.Join("testdata", test.name+".golden")
+
+			if *update {
+				if err := os.WriteFile(golden, encoded.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s; run go test -update to create it", err)
+			}
+
+			if !bytes.Equal(encoded.Bytes(), want) {
+				t.Errorf("descriptor differs from %s:\ngot:\n%s\nwant:\n%s", golden, encoded.Bytes(), want)
+			}
+
+			decoded, err := typedesc.Decode(&encoded)
+			if err != nil {
+				t.Fatalf("Decode: %s", err)
+			}
+
+			if !reflect.DeepEqual(decoded, descriptor) {
+				t.Errorf("Decode(Encode(d)) = %+v, want %+v", decoded, descriptor)
+			}
+		})
+	}
+}
+
+func TestDescriptorResolve(t *testing.T) {
+	descriptor := typedesc.Describe(pkgPath, reflect.TypeOf(Node{}), nil, nil)
+
+	parent := descriptor.Type.Fields[1].Type
+
+	if parent.Kind != "ptr" || parent.Elem.Ref != pkgPath+".Node" {
+		t.Fatalf("Parent field type = %+v, want a pointer to a reference to Node", parent)
+	}
+
+	if resolved := descriptor.Resolve(parent.Elem); resolved != descriptor.Type {
+		t.Errorf("Resolve(%+v) = %+v, want the root type", parent.Elem, resolved)
+	}
+
+	value := descriptor.Type.Fields[0].Type
+
+	if resolved := descriptor.Resolve(value); resolved != value {
+		t.Errorf("Resolve(%+v) = %+v, want the type itself", value, resolved)
+	}
+}
+
+func TestDecodeVersion(t *testing.T) {
+	if _, err := typedesc.Decode(bytes.NewReader([]byte(`{"Version": 0}`))); err == nil {
+		t.Error("Decode of a descriptor of another version succeeded")
+	}
+
+	if _, err := typedesc.Decode(bytes.NewReader([]byte(`{`))); err == nil {
+		t.Error("Decode of invalid JSON succeeded")
+	}
+}
+
+// parseFixtures returns the type declarations of the fixtures by name and
+// their function declarations by name, qualified by the receiver type for
+// methods.
+func parseFixtures(t *testing.T) (map[string]*ast.TypeSpec, map[string]*ast.FuncDecl) {
+	t.Helper()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "fixtures_test.go", nil, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		specs = make(map[string]*ast.TypeSpec)
+		funcs = make(map[string]*ast.FuncDecl)
+	)
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+					// the doc of an ungrouped declaration is on the GenDecl,
+					// as craft passes it
+					typeSpec.Doc = d.Doc
+					specs[typeSpec.Name.Name] = typeSpec
+				}
+			}
+		case *ast.FuncDecl:
+			name := d.Name.Name
+
+			if d.Recv != nil {
+				recvType := d.Recv.List[0].Type
+
+				if star, ok := recvType.(*ast.StarExpr); ok {
+					recvType = star.X
+				}
+
+				if index, ok := recvType.(*ast.IndexExpr); ok {
+					recvType = index.X
+				}
+
+				name = recvType.(*ast.Ident).Name + "." + name
+			}
+
+			funcs[name] = d
+		}
+	}
+
+	return specs, funcs
+}
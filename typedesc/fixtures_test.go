@@ -0,0 +1,53 @@
+package typedesc_test
+
+import "io"
+
+// User is a user of the service.
+type User struct {
+	// Name is the display name.
+	Name  string `json:"name"`
+	Email string // the primary address
+	age   int
+	io.Reader
+}
+
+// Node is a node of a tree.
+type Node struct {
+	Value    int
+	Parent   *Node
+	Children []Node
+	Index    map[string]*Node
+}
+
+// Pair holds a key and its value.
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V `json:"value"`
+}
+
+// Counter counts.
+type Counter int
+
+// Inc adds by to the counter.
+func (c *Counter) Inc(by int) {}
+
+// Value returns the count.
+func (c Counter) Value() (count int) { return int(c) }
+
+// Store stores values.
+type Store interface {
+	// Get returns the value of the key.
+	Get(key string) (value []byte, ok bool)
+	Put(key string, value []byte) error
+}
+
+// Stack is a stack of values.
+type Stack[T any] struct {
+	values []T
+}
+
+// Push pushes the values on the stack.
+func (s *Stack[T]) Push(values ...T) {}
+
+// Sum returns the sum of the numbers.
+func Sum(numbers ...int) (sum int) { return 0 }
@@ -0,0 +1,144 @@
+// Package typedesc defines a serializable description of the declaration a
+// macro is invoked on. Unlike a reflect.Type, a descriptor exists outside of
+// the program that built it: it can be sent to a macro in another process or
+// language, cached, and compared against golden files.
+//
+// A descriptor is built from the reflect.Type of the declaration together
+// with its source, so it also carries what reflection loses: doc comments,
+// parameter names and type parameters.
+package typedesc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Version is the version of the descriptor format. It changes whenever a
+// descriptor changes in a way a reader of the previous version would
+// misread.
+const Version = 1
+
+// Descriptor describes a declaration.
+//
+// Type is the type of the declaration. Named types it refers to are not
+// described inline but referenced by their ID, and described once in Named,
+// so recursive types are finite. Types declared in the package of the
+// declaration are described in full, other named types only by name.
+type Descriptor struct {
+	Version int
+	Type    *Type
+	Named   map[string]*Type `json:",omitempty"`
+}
+
+// Lookup returns the named type with the ID, which may be Type itself, or
+// nil if the descriptor does not describe it.
+func (d *Descriptor) Lookup(id string) *Type {
+	if d.Type != nil && d.Type.ID == id {
+		return d.Type
+	}
+
+	return d.Named[id]
+}
+
+// Resolve returns the named type t refers to, or t itself if it is not a
+// reference.
+func (d *Descriptor) Resolve(t *Type) *Type {
+	if t == nil || t.Ref == "" {
+		return t
+	}
+
+	if named := d.Lookup(t.Ref); named != nil {
+		return named
+	}
+
+	return t
+}
+
+// Type describes a type.
+//
+// Kind is the name of the reflect.Kind of the type, e.g. "struct", or empty
+// if the kind is not known, as for the field types of a generic declaration.
+// A reference to a named type has Ref set to the ID of the type and only
+// carries its Kind, Name and PkgPath.
+//
+// Elem is set for arrays, channels, maps, pointers and slices and Key for
+// maps. Params, Results and Variadic are set for functions, along with the
+// ParamNames and ResultNames of the source if the function is the
+// declaration or a method of it. Fields are set for structs and Methods for
+// interfaces and named types with methods.
+type Type struct {
+	Kind        string      `json:",omitempty"`
+	ID          string      `json:",omitempty"`
+	Ref         string      `json:",omitempty"`
+	Name        string      `json:",omitempty"`
+	PkgPath     string      `json:",omitempty"`
+	String      string      `json:",omitempty"`
+	Doc         string      `json:",omitempty"`
+	TypeParams  []TypeParam `json:",omitempty"`
+	Elem        *Type       `json:",omitempty"`
+	Key         *Type       `json:",omitempty"`
+	Len         int         `json:",omitempty"`
+	ChanDir     string      `json:",omitempty"`
+	Params      []*Type     `json:",omitempty"`
+	ParamNames  []string    `json:",omitempty"`
+	Results     []*Type     `json:",omitempty"`
+	ResultNames []string    `json:",omitempty"`
+	Variadic    bool        `json:",omitempty"`
+	Fields      []Field     `json:",omitempty"`
+	Methods     []Method    `json:",omitempty"`
+}
+
+// TypeParam is a type parameter of a generic declaration. Constraint is the
+// source of its constraint, empty for the type parameters of the receiver of
+// a method, whose constraints are declared by the receiver type.
+type TypeParam struct {
+	Name       string
+	Constraint string
+}
+
+// Field is a struct field. Doc and Comment are the text of its doc and line
+// comments, set for the fields of the declaration.
+type Field struct {
+	Name     string
+	Type     *Type
+	Tag      string `json:",omitempty"`
+	Embedded bool   `json:",omitempty"`
+	Exported bool   `json:",omitempty"`
+	Doc      string `json:",omitempty"`
+	Comment  string `json:",omitempty"`
+}
+
+// Method is a method of an interface or a named type. Type is the function
+// type of the method without its receiver. PointerReceiver is true for a
+// method of a named type declared on a pointer receiver.
+type Method struct {
+	Name            string
+	Type            *Type
+	PointerReceiver bool   `json:",omitempty"`
+	Doc             string `json:",omitempty"`
+}
+
+// Encode writes the descriptor to w as JSON.
+func Encode(w io.Writer, d *Descriptor) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+
+	return encoder.Encode(d)
+}
+
+// Decode reads a descriptor written by Encode from r. It fails if the
+// descriptor is of another version.
+func Decode(r io.Reader) (*Descriptor, error) {
+	var d Descriptor
+
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return nil, fmt.Errorf("typedesc: %w", err)
+	}
+
+	if d.Version != Version {
+		return nil, fmt.Errorf("typedesc: descriptor version %d, want %d", d.Version, Version)
+	}
+
+	return &d, nil
+}